@@ -1,69 +1,259 @@
 package terrarium
 
 import (
-	"fmt"
+	"bufio"
+	"context"
+	"errors"
 	"image"
-	"image/png"
-	"io"
 	"net/http"
+	"net/textproto"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
+)
+
+const (
+	DefaultRetries    = 3
+	DefaultRetryDelay = 500 * time.Millisecond
 )
 
 type Cache struct {
 	URLTemplate  string
 	Directory    string
 	MaxDownloads int
-	sem          chan int
-	wg           *sync.WaitGroup
+	Retries      int
+	RetryDelay   time.Duration
+	OnProgress   ProgressFunc
+	Encoding     ElevationEncoding
+
+	client          *http.Client
+	header          http.Header
+	limiter         *rateLimiter
+	revalidateAfter time.Duration
+	memory          *tileLRU
+	downloads       *flightGroup
+	loads           *flightGroup
+	onDemand        bool
+	offline         bool
+	tileSize        int
+
+	sem      chan int
+	wg       *sync.WaitGroup
+	mu       sync.Mutex
+	errs     TileErrors
+	progress Progress
 }
 
-func NewCache(urlTemplate, directory string, maxDownloads int) *Cache {
+func NewCache(urlTemplate, directory string, maxDownloads int, options ...CacheOption) *Cache {
 	sem := make(chan int, maxDownloads)
 	wg := &sync.WaitGroup{}
-	return &Cache{urlTemplate, directory, maxDownloads, sem, wg}
+	cache := &Cache{
+		URLTemplate:  urlTemplate,
+		Directory:    directory,
+		MaxDownloads: maxDownloads,
+		Retries:      DefaultRetries,
+		RetryDelay:   DefaultRetryDelay,
+		client:       http.DefaultClient,
+		header:       make(http.Header),
+		downloads:    newFlightGroup(),
+		loads:        newFlightGroup(),
+		tileSize:     TileSize,
+		sem:          sem,
+		wg:           wg,
+	}
+	for _, option := range options {
+		option(cache)
+	}
+	return cache
 }
 
 func (cache *Cache) EnsureTile(z, x, y int) {
+	cache.EnsureTileContext(context.Background(), z, x, y)
+}
+
+// EnsureTileContext queues a download of the tile if it is not already
+// cached or being downloaded. A download shared by several callers is
+// abandoned once the contexts of all of them are done.
+func (cache *Cache) EnsureTileContext(ctx context.Context, z, x, y int) {
+	cache.ensureTile(ctx, z, x, y, true)
+}
+
+// ensureTile returns the download call for the tile, which is already done
+// if the tile was cached. The download runs under the call's own context,
+// which is only cancelled when every caller's ctx is done. If collect is
+// false, a failure is only returned by the call and not by Wait, unless
+// another caller of the same download asked for it.
+func (cache *Cache) ensureTile(ctx context.Context, z, x, y int, collect bool) *flightCall {
+	id := TileID{z, x, y}
+	call, leader := cache.downloads.begin(id)
+	if collect {
+		cache.downloads.collectErrors(call)
+	}
+	cache.downloads.watch(ctx, call)
+	if !leader {
+		return call
+	}
 	path := cache.tilePath(z, x, y)
-	if _, err := os.Stat(path); err == nil {
-		return
+	info, err := os.Stat(path)
+	if err == nil && (cache.offline || !cache.isStale(info)) {
+		cache.downloads.finish(id, call, nil, nil)
+		cache.report(id, TileCached, 0, nil, false)
+		return call
+	}
+	if cache.offline {
+		collect := cache.downloads.finish(id, call, nil, ErrOffline)
+		cache.report(id, TileFailed, 0, ErrOffline, collect)
+		return call
 	}
+	cache.report(id, TileQueued, 0, nil, false)
 	cache.wg.Add(1)
-	go cache.tileWorker(z, x, y)
+	go cache.tileWorker(call.ctx, call, z, x, y)
+	return call
 }
 
-func (cache *Cache) Wait() {
-	cache.wg.Wait()
+// fetchTile downloads the tile if needed and waits for it to be cached.
+func (cache *Cache) fetchTile(ctx context.Context, z, x, y int) error {
+	call := cache.ensureTile(ctx, z, x, y, true)
+	select {
+	case <-call.done:
+		return call.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-func (cache *Cache) GetTile(z, x, y int) (*Tile, error) {
-	im, err := cache.getTileImage(z, x, y)
-	if err != nil {
-		return nil, err
+// Wait blocks until all pending downloads have finished. It returns a
+// TileErrors listing the tiles that could not be downloaded, if any.
+func (cache *Cache) Wait() error {
+	return cache.WaitContext(context.Background())
+}
+
+// WaitContext is like Wait but returns ctx.Err() early if ctx is done
+// before the pending downloads have finished.
+func (cache *Cache) WaitContext(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		cache.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	errs := cache.errs
+	cache.errs = nil
+	if len(errs) == 0 {
+		return nil
 	}
-	return newTile(z, x, y, im), nil
+	return errs
 }
 
-func (cache *Cache) GetStitchedTile(z, x, y int) (*Tile, error) {
-	im, err := stitchTile(cache, z, x, y)
-	if err != nil {
-		return nil, err
+// Progress returns the running totals for all tiles requested so far.
+func (cache *Cache) Progress() Progress {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	return cache.progress
+}
+
+// report updates the progress totals and notifies OnProgress. A failure is
+// also collected for Wait if collect is set.
+func (cache *Cache) report(id TileID, status TileStatus, bytes int64, err error, collect bool) {
+	cache.mu.Lock()
+	switch status {
+	case TileQueued:
+		cache.progress.Queued++
+	case TileDownloaded:
+		cache.progress.Downloaded++
+	case TileCached:
+		cache.progress.Cached++
+	case TileFailed:
+		cache.progress.Failed++
+		// downloads are only cancelled once nobody is waiting for them
+		if collect && !errors.Is(err, context.Canceled) {
+			cache.errs = append(cache.errs, &TileError{id, err})
+		}
+	case TileNotModified:
+		cache.progress.NotModified++
 	}
-	return newTile(z, x, y, im), nil
+	cache.progress.Bytes += bytes
+	progress := cache.progress
+	onProgress := cache.OnProgress
+	cache.mu.Unlock()
+	if onProgress != nil {
+		onProgress(ProgressEvent{id, status, bytes, err, progress})
+	}
+}
+
+func (cache *Cache) GetTile(z, x, y int) (*Tile, error) {
+	return GetTile(cache, z, x, y)
+}
+
+func (cache *Cache) GetStitchedTile(z, x, y int) (*Tile, error) {
+	return GetStitchedTile(cache, z, x, y)
+}
+
+func (cache *Cache) TileEncoding() ElevationEncoding {
+	return encodingOrDefault(cache.Encoding)
+}
+
+func (cache *Cache) GetTileAt(z int, p Point) (*Tile, error) {
+	return GetTileAt(cache, z, p)
+}
+
+// TileImage returns the decoded image for a cached tile. Images may be shared
+// through the in-memory cache and must not be modified.
+func (cache *Cache) TileImage(z, x, y int) (image.Image, error) {
+	return cache.TileImageContext(context.Background(), z, x, y)
 }
 
-func (cache *Cache) getTileImage(z, x, y int) (image.Image, error) {
+// TileImageContext is like TileImage. If the cache was created with
+// WithOnDemand, a missing tile is downloaded first, subject to ctx.
+func (cache *Cache) TileImageContext(ctx context.Context, z, x, y int) (image.Image, error) {
+	id := TileID{z, x, y}
+	if cache.memory != nil {
+		if im, ok := cache.memory.Get(id); ok {
+			return im, nil
+		}
+	}
 	path := cache.tilePath(z, x, y)
-	file, err := os.Open(path)
+	if cache.onDemand {
+		// each caller waits on the shared download with its own ctx
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			if err := cache.fetchTile(ctx, z, x, y); err != nil {
+				return nil, &TileError{id, err}
+			}
+		}
+	}
+	val, err := cache.loads.Do(id, func() (interface{}, error) {
+		im, err := loadTileImage(path)
+		if os.IsNotExist(err) {
+			return nil, &TileError{id, ErrTileNotFound}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if cache.memory != nil {
+			cache.memory.Add(id, im)
+		}
+		return im, nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
-	return png.Decode(file)
+	return val.(image.Image), nil
+}
+
+// MemoryStats reports hit and miss counts for the in-memory tile cache.
+func (cache *Cache) MemoryStats() MemoryStats {
+	if cache.memory == nil {
+		return MemoryStats{}
+	}
+	return cache.memory.Stats()
 }
 
 func (cache *Cache) tileURL(z, x, y int) string {
@@ -75,39 +265,131 @@ func (cache *Cache) tileURL(z, x, y int) string {
 }
 
 func (cache *Cache) tilePath(z, x, y int) string {
-	path := fmt.Sprintf("%d/%d/%d.png", z, x, y)
-	path = filepath.Join(cache.Directory, path)
-	return path
+	return tilePath(cache.Directory, z, x, y)
 }
 
-func (cache *Cache) tileDir(z, x, y int) string {
-	path := cache.tilePath(z, x, y)
-	dir, _ := filepath.Split(path)
-	return dir
+func (cache *Cache) isStale(info os.FileInfo) bool {
+	if cache.revalidateAfter <= 0 {
+		return false
+	}
+	return time.Since(info.ModTime()) > cache.revalidateAfter
 }
 
-func (cache *Cache) tileWorker(z, x, y int) {
+func (cache *Cache) tileWorker(ctx context.Context, call *flightCall, z, x, y int) {
 	defer cache.wg.Done()
-	cache.sem <- 1
-	err := cache.downloadTile(z, x, y)
-	<-cache.sem
-	if err != nil {
-		panic(err)
+	id := TileID{z, x, y}
+	var status TileStatus
+	var n int64
+	var err error
+	select {
+	case cache.sem <- 1:
+		status, n, err = cache.downloadTile(ctx, z, x, y)
+		<-cache.sem
+	case <-ctx.Done():
+		status, err = TileFailed, ctx.Err()
 	}
+	collect := cache.downloads.finish(id, call, nil, err)
+	cache.report(id, status, n, err, collect)
 }
 
-func (cache *Cache) downloadTile(z, x, y int) error {
-	os.MkdirAll(cache.tileDir(z, x, y), os.ModePerm)
+func (cache *Cache) downloadTile(ctx context.Context, z, x, y int) (TileStatus, int64, error) {
+	delay := cache.RetryDelay
+	for i := 0; ; i++ {
+		status, n, err := cache.downloadTileOnce(ctx, z, x, y)
+		if err == nil || i >= cache.Retries || ctx.Err() != nil || !isRetryable(err) {
+			return status, n, err
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return TileFailed, 0, ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+func (cache *Cache) downloadTileOnce(ctx context.Context, z, x, y int) (TileStatus, int64, error) {
+	if err := cache.limiter.Wait(ctx); err != nil {
+		return TileFailed, 0, err
+	}
+	url := cache.tileURL(z, x, y)
+	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return TileFailed, 0, err
+	}
+	for key, values := range cache.header {
+		request.Header[key] = values
+	}
 	path := cache.tilePath(z, x, y)
-	file, err := os.Create(path)
+	if _, err := os.Stat(path); err == nil {
+		meta := readTileMeta(path)
+		if etag := meta.Get("ETag"); etag != "" {
+			request.Header.Set("If-None-Match", etag)
+		}
+		if modified := meta.Get("Last-Modified"); modified != "" {
+			request.Header.Set("If-Modified-Since", modified)
+		}
+	}
+	response, err := cache.client.Do(request)
+	if err != nil {
+		return TileFailed, 0, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotModified {
+		now := time.Now()
+		os.Chtimes(path, now, now)
+		return TileNotModified, 0, nil
+	}
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return TileFailed, 0, &StatusError{url, response.StatusCode}
+	}
+
+	n, err := writeAtomic(path, response.Body)
+	if err != nil {
+		return TileFailed, 0, err
+	}
+	writeTileMeta(path, response.Header)
+	if cache.memory != nil {
+		cache.memory.Remove(TileID{z, x, y})
+	}
+	return TileDownloaded, n, nil
+}
+
+// tile validators are kept next to the tile in a small sidecar file so
+// that later revalidation can use conditional requests
+func tileMetaPath(path string) string {
+	return path + ".meta"
+}
+
+func readTileMeta(path string) textproto.MIMEHeader {
+	file, err := os.Open(tileMetaPath(path))
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	meta, _ := textproto.NewReader(bufio.NewReader(file)).ReadMIMEHeader()
+	return meta
+}
+
+func writeTileMeta(path string, header http.Header) error {
+	meta := make(http.Header)
+	for _, key := range []string{"ETag", "Last-Modified"} {
+		if value := header.Get(key); value != "" {
+			meta.Set(key, value)
+		}
+	}
+	if len(meta) == 0 {
+		os.Remove(tileMetaPath(path))
+		return nil
+	}
+	file, err := os.Create(tileMetaPath(path))
 	if err != nil {
 		return err
 	}
 	defer file.Close()
-	url := cache.tileURL(z, x, y)
-	response, err := http.Get(url)
-	defer response.Body.Close()
-	_, err = io.Copy(file, response.Body)
-	fmt.Println(path)
+	if err := meta.Write(file); err != nil {
+		return err
+	}
+	_, err = file.WriteString("\r\n")
 	return err
 }
@@ -0,0 +1,78 @@
+package terrarium
+
+import (
+	"bytes"
+	"context"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTileServer(t *testing.T, delay time.Duration) (*httptest.Server, *int32) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, EncodeTerrarium(make([]float64, 16*16), 16, 16)); err != nil {
+		t.Fatal(err)
+	}
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		w.Write(buf.Bytes())
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestCancelledCallerDoesNotFailOthers(t *testing.T) {
+	server, _ := newTileServer(t, 20*time.Millisecond)
+	cache := NewCache(server.URL+"/{z}/{x}/{y}.png", t.TempDir(), 4, WithOnDemand())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := cache.TileImageContext(ctx, 3, 5, 5); err == nil {
+		t.Fatal("expected an error for a cancelled context")
+	}
+	if _, err := cache.TileImage(3, 5, 5); err != nil {
+		t.Fatalf("TileImage after another caller cancelled: %v", err)
+	}
+	if err := cache.Wait(); err != nil {
+		t.Fatalf("Wait reported another caller's cancellation: %v", err)
+	}
+}
+
+func TestSharedDownloadSurvivesOneCancellation(t *testing.T) {
+	server, requests := newTileServer(t, 100*time.Millisecond)
+	cache := NewCache(server.URL+"/{z}/{x}/{y}.png", t.TempDir(), 4, WithOnDemand())
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := cache.TileImageContext(ctx, 3, 5, 5)
+		first <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	second := make(chan error, 1)
+	go func() {
+		_, err := cache.TileImage(3, 5, 5)
+		second <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	if err := <-first; err == nil {
+		t.Error("expected the cancelled caller to stop waiting with an error")
+	}
+	if err := <-second; err != nil {
+		t.Fatalf("waiting caller failed: %v", err)
+	}
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Errorf("got %d requests, want the download to be shared", n)
+	}
+	if err := cache.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -37,25 +37,31 @@ func main() {
 	// 	fmt.Println(k, hist[float64(k)])
 	// }
 
-	var paths []terrarium.Path
-	for i := 0; i < 65535; i += 1024 {
-		z := float64(i)
-		// if hist[z] == 0 {
-		// 	continue
-		// }
-		p := terrarium.Slice(a, w, h, z+1e-7)
-		fmt.Println(z, len(p))
-		paths = append(paths, p...)
+	levels := terrarium.IntervalLevels(0, 65535, 1024, 0, 8)
+	// levels := terrarium.QuantileLevels(a, 64).IndexEvery(8)
+	contours := terrarium.SliceContours(terrarium.Triangles, a, w, h, levels)
+	counts := make(map[float64]int)
+	var paths, index []terrarium.Path
+	for _, c := range contours {
+		counts[c.Level]++
+		if c.Index {
+			index = append(index, c.Path)
+		} else {
+			paths = append(paths, c.Path)
+		}
+	}
+	for _, level := range levels {
+		fmt.Println(level.Value, counts[level.Value])
 	}
 
 	fmt.Println("rendering image...")
-	im := renderPaths(paths, Size, Padding, LineWidth)
+	im := renderPaths(paths, index, Size, Padding, LineWidth)
 
 	fmt.Println("writing png...")
 	gg.SavePNG("out.png", im)
 
 	// fmt.Println("writing axi...")
-	// saveAxi("out.axi", paths)
+	// saveAxi("out.axi", append(paths, index...))
 }
 
 func ensureGray16(im image.Image) (*image.Gray16, bool) {
@@ -123,12 +129,14 @@ func saveAxi(filename string, paths []terrarium.Path) error {
 	return w.Flush()
 }
 
-func renderPaths(paths []terrarium.Path, size, pad int, lw float64) image.Image {
-	x0 := paths[0][0].X
-	x1 := paths[0][0].X
-	y0 := paths[0][0].Y
-	y1 := paths[0][0].Y
-	for _, path := range paths {
+// renderPaths draws the paths, with the index paths twice as thick.
+func renderPaths(paths, index []terrarium.Path, size, pad int, lw float64) image.Image {
+	all := append(append([]terrarium.Path(nil), paths...), index...)
+	x0 := all[0][0].X
+	x1 := all[0][0].X
+	y0 := all[0][0].Y
+	y1 := all[0][0].Y
+	for _, path := range all {
 		for _, p := range path {
 			if p.X < x0 {
 				x0 = p.X
@@ -164,6 +172,14 @@ func renderPaths(paths []terrarium.Path, size, pad int, lw float64) image.Image
 	dc.SetRGB(0, 0, 0)
 	dc.SetLineWidth(lw)
 	dc.Stroke()
+	for _, path := range index {
+		dc.NewSubPath()
+		for _, p := range path {
+			dc.LineTo(p.X, p.Y)
+		}
+	}
+	dc.SetLineWidth(lw * 2)
+	dc.Stroke()
 	// dc.Identity()
 	// dc.DrawCircle(float64(dc.Width()/2), float64(dc.Height()/2), 8)
 	// dc.SetRGBA(1, 0, 0, 0.9)
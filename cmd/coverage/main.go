@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fogleman/maps"
+	"github.com/fogleman/terrarium"
+)
+
+var (
+	z         = flag.Int("z", 9, "zoom level")
+	directory = flag.String("cache", "cache", "cache directory")
+	shapefile = flag.String("shapefile", "", "shapefile to use for the bounds")
+	tag       = flag.String("tag", "", "shapefile filter as KEY=VALUE")
+	decode    = flag.Bool("decode", false, "fully decode tiles to detect corruption")
+	verbose   = flag.Bool("v", false, "list missing and corrupt tiles")
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: coverage [flags] LAT0 LNG0 LAT1 LNG1")
+	fmt.Fprintln(os.Stderr, "       coverage [flags] -shapefile FILE.shp [-tag KEY=VALUE]")
+	flag.PrintDefaults()
+	os.Exit(1)
+}
+
+func loadBounds() (terrarium.Bounds, error) {
+	if *shapefile != "" {
+		var filters []maps.ShapeFilter
+		if *tag != "" {
+			kv := strings.SplitN(*tag, "=", 2)
+			if len(kv) != 2 {
+				usage()
+			}
+			filters = append(filters, maps.NewShapeTagFilter(kv[0], kv[1]))
+		}
+		shapes, err := maps.LoadShapefile(*shapefile, filters...)
+		if err != nil {
+			return terrarium.Bounds{}, err
+		}
+		if len(shapes) == 0 {
+			return terrarium.Bounds{}, fmt.Errorf("no shapes in %s", *shapefile)
+		}
+		b := maps.BoundsForShapes(shapes...)
+		return terrarium.Bounds{terrarium.Point(b.Min), terrarium.Point(b.Max)}, nil
+	}
+	if flag.NArg() != 4 {
+		usage()
+	}
+	var v [4]float64
+	for i := range v {
+		if _, err := fmt.Sscan(flag.Arg(i), &v[i]); err != nil {
+			return terrarium.Bounds{}, err
+		}
+	}
+	min := terrarium.LatLng(v[0], v[1])
+	max := terrarium.LatLng(v[2], v[3])
+	return terrarium.Bounds{min, max}, nil
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	bounds, err := loadBounds()
+	if err != nil {
+		panic(err)
+	}
+	cache := terrarium.NewCache("", *directory, 1, terrarium.WithOffline())
+	report := cache.Coverage(*z, bounds, *decode)
+	fmt.Printf("zoom    %d\n", *z)
+	fmt.Printf("tiles   %d\n", report.Total())
+	fmt.Printf("present %d\n", len(report.Present))
+	fmt.Printf("missing %d\n", len(report.Missing))
+	fmt.Printf("corrupt %d\n", len(report.Corrupt))
+	fmt.Printf("size    %.1f MB\n", float64(report.Bytes)/(1<<20))
+	if *verbose {
+		for _, id := range report.Missing {
+			fmt.Printf("missing %d/%d/%d\n", id.Z, id.X, id.Y)
+		}
+		for _, id := range report.Corrupt {
+			fmt.Printf("corrupt %d/%d/%d\n", id.Z, id.X, id.Y)
+		}
+	}
+	if !report.Complete() {
+		os.Exit(2)
+	}
+}
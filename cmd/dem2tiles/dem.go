@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/fogleman/terrarium"
+)
+
+// dem is a grid of elevations on a regular lat/lng grid. (Lng0, Lat0) is the
+// location of the centre of the top left sample and DLng, DLat are the
+// spacing between samples (DLat is negative for north-up grids).
+type dem struct {
+	W, H       int
+	Data       []float64
+	Lng0, Lat0 float64
+	DLng, DLat float64
+	NoData     float64
+	HasNoData  bool
+}
+
+func (d *dem) Bounds() terrarium.Bounds {
+	lng0 := d.Lng0 - d.DLng/2
+	lat0 := d.Lat0 - d.DLat/2
+	lng1 := lng0 + d.DLng*float64(d.W)
+	lat1 := lat0 + d.DLat*float64(d.H)
+	return terrarium.Bounds{
+		terrarium.LatLng(math.Min(lat0, lat1), math.Min(lng0, lng1)),
+		terrarium.LatLng(math.Max(lat0, lat1), math.Max(lng0, lng1)),
+	}
+}
+
+func (d *dem) valid(v float64) bool {
+	return !math.IsNaN(v) && !(d.HasNoData && v == d.NoData)
+}
+
+// Elevation bilinearly interpolates the grid. Samples next to nodata fall
+// back to the nearest valid sample.
+func (d *dem) Elevation(lat, lng float64) (float64, bool) {
+	fx := (lng - d.Lng0) / d.DLng
+	fy := (lat - d.Lat0) / d.DLat
+	if fx < -0.5 || fy < -0.5 || fx > float64(d.W)-0.5 || fy > float64(d.H)-0.5 {
+		return 0, false
+	}
+	fx = math.Max(0, math.Min(fx, float64(d.W-1)))
+	fy = math.Max(0, math.Min(fy, float64(d.H-1)))
+	x0 := int(fx)
+	y0 := int(fy)
+	x1 := x0 + 1
+	y1 := y0 + 1
+	if x1 >= d.W {
+		x1 = d.W - 1
+	}
+	if y1 >= d.H {
+		y1 = d.H - 1
+	}
+	tx := fx - float64(x0)
+	ty := fy - float64(y0)
+	v00 := d.Data[y0*d.W+x0]
+	v10 := d.Data[y0*d.W+x1]
+	v01 := d.Data[y1*d.W+x0]
+	v11 := d.Data[y1*d.W+x1]
+	if d.valid(v00) && d.valid(v10) && d.valid(v01) && d.valid(v11) {
+		v0 := v00 + (v10-v00)*tx
+		v1 := v01 + (v11-v01)*tx
+		return v0 + (v1-v0)*ty, true
+	}
+	v := d.Data[int(math.Round(fy))*d.W+int(math.Round(fx))]
+	return v, d.valid(v)
+}
+
+// readHGT reads an SRTM .hgt file. The location comes from the file name,
+// e.g. N37W120.hgt covers 37..38 N, 120..119 W.
+func readHGT(path string) (*dem, error) {
+	name := strings.ToUpper(filepath.Base(path))
+	var ns, ew byte
+	var lat, lng int
+	if _, err := fmt.Sscanf(name, "%c%2d%c%3d", &ns, &lat, &ew, &lng); err != nil {
+		return nil, fmt.Errorf("%s: cannot parse location from file name", path)
+	}
+	if ns == 'S' {
+		lat = -lat
+	}
+	if ew == 'W' {
+		lng = -lng
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	n := int(math.Sqrt(float64(len(buf) / 2)))
+	if n*n*2 != len(buf) {
+		return nil, fmt.Errorf("%s: unexpected file size %d", path, len(buf))
+	}
+	data := make([]float64, n*n)
+	for i := range data {
+		data[i] = float64(int16(binary.BigEndian.Uint16(buf[i*2:])))
+	}
+	step := 1 / float64(n-1)
+	return &dem{n, n, data, float64(lng), float64(lat + 1), step, -step, -32768, true}, nil
+}
+
+// readASCIIGrid reads an ESRI ASCII grid (.asc) in geographic coordinates.
+func readASCIIGrid(path string) (*dem, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+	header := make(map[string]float64)
+	var first string
+	for {
+		var key string
+		if _, err := fmt.Fscan(r, &key); err != nil {
+			return nil, err
+		}
+		k := strings.ToLower(key)
+		if _, err := strconv.ParseFloat(key, 64); err == nil {
+			first = key
+			break
+		}
+		var value float64
+		if _, err := fmt.Fscan(r, &value); err != nil {
+			return nil, err
+		}
+		header[k] = value
+	}
+	w := int(header["ncols"])
+	h := int(header["nrows"])
+	cellsize := header["cellsize"]
+	if w <= 0 || h <= 0 || cellsize <= 0 {
+		return nil, fmt.Errorf("%s: invalid header", path)
+	}
+	d := &dem{W: w, H: h, DLng: cellsize, DLat: -cellsize}
+	if v, ok := header["xllcenter"]; ok {
+		d.Lng0 = v
+	} else {
+		d.Lng0 = header["xllcorner"] + cellsize/2
+	}
+	if v, ok := header["yllcenter"]; ok {
+		d.Lat0 = v + cellsize*float64(h-1)
+	} else {
+		d.Lat0 = header["yllcorner"] + cellsize*float64(h) - cellsize/2
+	}
+	if v, ok := header["nodata_value"]; ok {
+		d.NoData = v
+		d.HasNoData = true
+	}
+	d.Data = make([]float64, w*h)
+	d.Data[0], _ = strconv.ParseFloat(first, 64)
+	for i := 1; i < len(d.Data); i++ {
+		if _, err := fmt.Fscan(r, &d.Data[i]); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return d, nil
+}
+
+// readPNG16 reads a 16-bit grayscale PNG using the given encoding. bounds
+// gives the outer edges of the image.
+func readPNG16(path string, encoding terrarium.GrayEncoding, bounds terrarium.Bounds) (*dem, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	im, err := png.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+	size := im.Bounds().Size()
+	d := newDEM(size.X, size.Y, bounds)
+	d.Data = encoding.DecodeElevation(im)
+	return d, nil
+}
+
+// readFloat32 reads raw little-endian float32 samples. bounds gives the
+// outer edges of the grid.
+func readFloat32(path string, w, h int, bounds terrarium.Bounds) (*dem, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	buf := make([]byte, w*h*4)
+	if _, err := io.ReadFull(bufio.NewReader(file), buf); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	d := newDEM(w, h, bounds)
+	d.Data = make([]float64, w*h)
+	for i := range d.Data {
+		d.Data[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(buf[i*4:])))
+	}
+	return d, nil
+}
+
+func newDEM(w, h int, bounds terrarium.Bounds) *dem {
+	dlng := (bounds.Max.X - bounds.Min.X) / float64(w)
+	dlat := (bounds.Max.Y - bounds.Min.Y) / float64(h)
+	return &dem{
+		W:    w,
+		H:    h,
+		Lng0: bounds.Min.X + dlng/2,
+		Lat0: bounds.Max.Y - dlat/2,
+		DLng: dlng,
+		DLat: -dlat,
+	}
+}
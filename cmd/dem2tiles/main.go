@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fogleman/terrarium"
+)
+
+var (
+	output  = flag.String("o", "tiles", "output directory")
+	minZoom = flag.Int("minzoom", 0, "minimum zoom level")
+	maxZoom = flag.Int("maxzoom", 12, "maximum zoom level")
+	format  = flag.String("format", "", "input format: hgt, asc, png or f32 (default from extension)")
+	bounds  = flag.String("bounds", "", "outer edges of png and f32 inputs as LAT0,LNG0,LAT1,LNG1")
+	width   = flag.Int("w", 0, "width of f32 input")
+	height  = flag.Int("h", 0, "height of f32 input")
+	scale   = flag.Float64("scale", 1, "meters per unit of png input")
+	offset  = flag.Float64("offset", 0, "meters at zero in png input")
+	srcNull = flag.String("srcnodata", "", "nodata value in the input, if any (the raw value for png)")
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: dem2tiles [flags] INPUT")
+	flag.PrintDefaults()
+	os.Exit(1)
+}
+
+func parseBounds(s string) (terrarium.Bounds, error) {
+	var lat0, lng0, lat1, lng1 float64
+	_, err := fmt.Sscanf(s, "%f,%f,%f,%f", &lat0, &lng0, &lat1, &lng1)
+	if err != nil {
+		return terrarium.Bounds{}, fmt.Errorf("invalid bounds %q", s)
+	}
+	min := terrarium.LatLng(lat0, lng0)
+	max := terrarium.LatLng(lat1, lng1)
+	return terrarium.Bounds{min, max}, nil
+}
+
+func inputFormat(path string) string {
+	if *format != "" {
+		return *format
+	}
+	return strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+}
+
+func readDEM(path string) (*dem, error) {
+	f := inputFormat(path)
+	switch f {
+	case "hgt":
+		return readHGT(path)
+	case "asc":
+		return readASCIIGrid(path)
+	case "png", "f32":
+		b, err := parseBounds(*bounds)
+		if err != nil {
+			return nil, err
+		}
+		if f == "png" {
+			encoding := terrarium.GrayEncoding{*scale, *offset, 0, false}
+			if *srcNull != "" {
+				if _, err := fmt.Sscan(*srcNull, &encoding.NoData); err != nil {
+					return nil, fmt.Errorf("invalid png nodata value %q", *srcNull)
+				}
+				encoding.HasNoData = true
+			}
+			return readPNG16(path, encoding, b)
+		}
+		if *width <= 0 || *height <= 0 {
+			return nil, fmt.Errorf("-w and -h are required for f32 input")
+		}
+		return readFloat32(path, *width, *height, b)
+	}
+	return nil, fmt.Errorf("unknown input format %q", f)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 1 {
+		usage()
+	}
+
+	fmt.Println("reading dem...")
+	d, err := readDEM(flag.Arg(0))
+	if err != nil {
+		panic(err)
+	}
+	// png nodata is a raw value and is handled while decoding
+	if *srcNull != "" && inputFormat(flag.Arg(0)) != "png" {
+		if _, err := fmt.Sscan(*srcNull, &d.NoData); err != nil {
+			panic(err)
+		}
+		d.HasNoData = true
+	}
+	b := d.Bounds()
+	fmt.Printf("%d x %d samples, %v\n", d.W, d.H, b)
+
+	fmt.Println("building pyramid...")
+	count := 0
+	options := terrarium.PyramidOptions{
+		MinZoom: *minZoom,
+		MaxZoom: *maxZoom,
+		Bounds:  b,
+		OnTile: func(z, x, y int) {
+			count++
+			if count%100 == 0 {
+				fmt.Printf("%d tiles (%d/%d/%d)\n", count, z, x, y)
+			}
+		},
+	}
+	if err := terrarium.BuildPyramid(*output, d.Elevation, options); err != nil {
+		panic(err)
+	}
+	fmt.Printf("%d tiles\n", count)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/fogleman/terrarium"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func usage() {
+	fmt.Println("usage:")
+	fmt.Println("  mbtiles pack DIRECTORY FILE.mbtiles")
+	fmt.Println("  mbtiles unpack FILE.mbtiles DIRECTORY")
+	os.Exit(1)
+}
+
+func main() {
+	if len(os.Args) != 4 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "pack":
+		directory, path := os.Args[2], os.Args[3]
+		m, err := terrarium.CreateMBTiles(path, directory)
+		if err != nil {
+			panic(err)
+		}
+		defer m.Close()
+		n, err := m.PackDirectory(directory)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("packed %d tiles\n", n)
+	case "unpack":
+		path, directory := os.Args[2], os.Args[3]
+		m, err := terrarium.OpenMBTiles(path)
+		if err != nil {
+			panic(err)
+		}
+		defer m.Close()
+		n, err := m.UnpackDirectory(directory)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("unpacked %d tiles\n", n)
+	default:
+		usage()
+	}
+}
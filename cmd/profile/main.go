@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fogleman/gg"
+	"github.com/fogleman/terrarium"
+)
+
+const URLTemplate = "https://s3.amazonaws.com/elevation-tiles-prod/terrarium/{z}/{x}/{y}.png"
+
+var (
+	z         = flag.Int("z", 12, "zoom level")
+	directory = flag.String("cache", "cache", "cache directory")
+	offline   = flag.Bool("offline", false, "only use tiles already in the cache")
+	spacing   = flag.Float64("spacing", 30, "sample spacing in meters")
+	output    = flag.String("o", "", "write a chart to FILE.png or FILE.svg")
+	width     = flag.Int("w", 1200, "chart width")
+	height    = flag.Int("h", 400, "chart height")
+	csv       = flag.Bool("csv", false, "print distance,elevation samples")
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: profile [flags] TRACK.gpx|TRACK.geojson")
+	flag.PrintDefaults()
+	os.Exit(1)
+}
+
+func loadPath(path string) (terrarium.Path, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".gpx":
+		return terrarium.ReadGPX(file)
+	default:
+		return terrarium.ReadGeoJSONLineString(file)
+	}
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 1 {
+		usage()
+	}
+	path, err := loadPath(flag.Arg(0))
+	if err != nil {
+		panic(err)
+	}
+	options := []terrarium.CacheOption{terrarium.WithOnDemand()}
+	if *offline {
+		options = append(options, terrarium.WithOffline())
+	}
+	cache := terrarium.NewCache(URLTemplate, *directory, 16, options...)
+	sampler := cache.Sampler(*z, terrarium.Bilinear)
+	profile, err := terrarium.ElevationProfile(sampler, path, *spacing)
+	if err != nil {
+		panic(err)
+	}
+	if *csv {
+		fmt.Println("distance,elevation")
+		for _, p := range profile.Points {
+			fmt.Printf("%.1f,%.2f\n", p.Distance, p.Elevation)
+		}
+	} else {
+		fmt.Printf("distance  %.2f km\n", profile.Distance/1000)
+		fmt.Printf("ascent    %.0f m\n", profile.Ascent)
+		fmt.Printf("descent   %.0f m\n", profile.Descent)
+		fmt.Printf("min       %.0f m\n", profile.MinElevation)
+		fmt.Printf("max       %.0f m\n", profile.MaxElevation)
+		fmt.Printf("max grade %.1f%%\n", profile.MaxGrade*100)
+	}
+	switch strings.ToLower(filepath.Ext(*output)) {
+	case "":
+	case ".svg":
+		err = ioutil.WriteFile(*output, []byte(profile.SVG(*width, *height)), 0644)
+	default:
+		err = gg.SavePNG(*output, profile.Render(*width, *height))
+	}
+	if err != nil {
+		panic(err)
+	}
+}
@@ -2,12 +2,8 @@ package main
 
 import (
 	"fmt"
-	"image"
-	"image/draw"
-	"math"
 
 	"github.com/fogleman/gg"
-	"github.com/fogleman/maps"
 	"github.com/fogleman/terrarium"
 	"github.com/umahmood/haversine"
 )
@@ -44,6 +40,7 @@ const (
 	URLTemplate    = "https://s3.amazonaws.com/elevation-tiles-prod/terrarium/{z}/{x}/{y}.png"
 	CacheDirectory = "cache"
 	MaxDownloads   = 16
+	MemoryTiles    = 256
 )
 
 func boundingBox(lat, lng, widthKm, heightKm float64) (float64, float64, float64, float64) {
@@ -67,111 +64,40 @@ func main() {
 	lat0, lng0, lat1, lng1 := boundingBox(Lat, Lng, W, H)
 	min := terrarium.LatLng(lat0, lng0)
 	max := terrarium.LatLng(lat1, lng1)
-	bounds := maps.Bounds{maps.Point(min), maps.Point(max)}
-	line := maps.NewPolyline([]maps.Point{
-		maps.Point{min.X, min.Y},
-		maps.Point{max.X, min.Y},
-		maps.Point{max.X, max.Y},
-		maps.Point{min.X, max.Y},
-		maps.Point{min.X, min.Y},
-	})
-	shape := maps.Shape{bounds, []*maps.Polyline{line}, nil}
-	shapes := []maps.Shape{shape}
-
-	// var result []maps.Shape
+	bounds := terrarium.Bounds{min, max}
+	fmt.Printf("%d tiles\n", terrarium.TileRangeForBounds(Z, bounds).Count())
+
+	fmt.Println("downloading tiles...")
+	cache := terrarium.NewCache(URLTemplate, CacheDirectory, MaxDownloads,
+		terrarium.WithMemoryCache(MemoryTiles, 0))
+	cache.OnProgress = printProgress
+	if err := cache.EnsureBounds(Z, bounds); err != nil {
+		panic(err)
+	}
+	if err := cache.Wait(); err != nil {
+		panic(err)
+	}
+
+	fmt.Println("stitching tiles...")
+	grid, err := cache.Grid(Z, bounds)
+	if err != nil {
+		panic(err)
+	}
+
 	// shapes, err := maps.LoadShapefile("ne_10m_admin_0_countries/wgs84.shp",
 	// 	maps.NewShapeTagFilter("NAME", "Iceland"))
 	// if err != nil {
 	// 	panic(err)
 	// }
+	// grid.MaskShapes(shapes)
 
-	p0 := terrarium.TileXY(Z, min)
-	p1 := terrarium.TileXY(Z, max)
-	x0 := p0.X
-	y0 := p0.Y
-	x1 := p1.X
-	y1 := p1.Y
-	if x1 < x0 {
-		x0, x1 = x1, x0
-	}
-	if y1 < y0 {
-		y0, y1 = y1, y0
-	}
-	x1++
-	y1++
-	n := (y1 - y0) * (x1 - x0)
-	fmt.Printf("%d tiles\n", n)
-
-	fmt.Println("downloading tiles...")
-	cache := terrarium.NewCache(URLTemplate, CacheDirectory, MaxDownloads)
-	for y := y0; y <= y1; y++ {
-		for x := x0; x <= x1; x++ {
-			cache.EnsureTile(Z, x, y)
-		}
-	}
-	cache.Wait()
-
-	lo := math.MaxFloat64
-	hi := -lo
-	for y := y0; y <= y1; y++ {
-		for x := x0; x <= x1; x++ {
-			tile, err := cache.GetTile(Z, x, y)
-			if err != nil {
-				panic(err)
-			}
-			lo = math.Min(lo, tile.MinElevation)
-			hi = math.Max(hi, tile.MaxElevation)
-		}
-	}
+	lo, hi := grid.Range()
 	// fmt.Println(lo, hi)
 
 	// lo = 0
 	// hi = 2000
 
-	fmt.Println("stitching tiles...")
-	const TileSize = terrarium.TileSize
-	w := (x1 - x0 + 1) * TileSize
-	h := (y1 - y0 + 1) * TileSize
-	im := image.NewGray16(image.Rect(0, 0, w, h))
-	for y := y0; y <= y1; y++ {
-		for x := x0; x <= x1; x++ {
-			tile, err := cache.GetTile(Z, x, y)
-			if err != nil {
-				panic(err)
-			}
-			tile.MaskShapes(shapes)
-			tx0 := (x - x0) * TileSize
-			ty0 := (y - y0) * TileSize
-			tx1 := tx0 + TileSize
-			ty1 := ty0 + TileSize
-			r := image.Rect(tx0, ty0, tx1, ty1)
-			draw.DrawMask(im, r, tile.AsGray16(lo, hi), image.ZP, tile.Mask, image.ZP, draw.Src)
-		}
-	}
-
-	px0 := w
-	py0 := h
-	px1 := 0
-	py1 := 0
-	for i, v := range im.Pix {
-		if v > 0 {
-			y := (i / 2) / w
-			x := (i / 2) % w
-			if x < px0 {
-				px0 = x
-			}
-			if y < py0 {
-				py0 = y
-			}
-			if x > px1 {
-				px1 = x
-			}
-			if y > py1 {
-				py1 = y
-			}
-		}
-	}
-	trimmed := im.SubImage(image.Rect(px0+1, py0+1, px1-1, py1-1))
+	im := grid.AsGray16(lo, hi)
 
 	_, lrKm := haversine.Distance(
 		haversine.Coord{Lat: lat0, Lon: lng0},
@@ -182,19 +108,28 @@ func main() {
 	xMeters := lrKm * 1000
 	yMeters := tbKm * 1000
 	zMeters := hi - lo
-	xMetersPerPixel := xMeters / float64(px1-px0)
-	yMetersPerPixel := yMeters / float64(py1-py0)
+	xMetersPerPixel := xMeters / float64(grid.W-1)
+	yMetersPerPixel := yMeters / float64(grid.H-1)
 	avgMetersPerPixel := (xMetersPerPixel + yMetersPerPixel) / 2
 	zScale := zMeters / avgMetersPerPixel
 	// fmt.Println(xMeters, yMeters, zMeters)
 	// fmt.Println(xMetersPerPixel, yMetersPerPixel)
-	fmt.Println("width =", trimmed.Bounds().Size().X)
-	fmt.Println("height =", trimmed.Bounds().Size().Y)
+	fmt.Println("width =", grid.W)
+	fmt.Println("height =", grid.H)
 	fmt.Println("min elevation =", lo)
 	fmt.Println("max elevation =", hi)
 	fmt.Println("elevation change =", hi-lo)
 	fmt.Println("z scale =", zScale)
 
-	gg.SavePNG("out.png", trimmed)
+	gg.SavePNG("out.png", im)
 
 }
+
+func printProgress(e terrarium.ProgressEvent) {
+	switch e.Status {
+	case terrarium.TileDownloaded:
+		fmt.Printf("%d/%d/%d (%d remaining)\n", e.Z, e.X, e.Y, e.Progress.Pending())
+	case terrarium.TileFailed:
+		fmt.Printf("%d/%d/%d failed: %v\n", e.Z, e.X, e.Y, e.Err)
+	}
+}
@@ -41,8 +41,15 @@ const (
 	// URLTemplate    = "https://maps.wikimedia.org/osm-intl/{z}/{x}/{y}.png"
 	// CacheDirectory = "cache-osm"
 	MaxDownloads = 16
+	MemoryTiles  = 256
+
+	// voids up to this many pixels are filled before contouring
+	MaxVoidSize = 10000
 )
 
+// every fourth level is drawn as a heavier index contour
+var levels = terrarium.IntervalLevels(-10000, 10000, Step, 0, 4)
+
 func loadShapes() ([]maps.Shape, error) {
 	var result []maps.Shape
 	if Country != "" {
@@ -117,13 +124,17 @@ func main() {
 	fmt.Printf("%d tiles\n", n)
 
 	fmt.Println("downloading tiles...")
-	cache := terrarium.NewCache(URLTemplate, CacheDirectory, MaxDownloads)
+	cache := terrarium.NewCache(URLTemplate, CacheDirectory, MaxDownloads,
+		terrarium.WithMemoryCache(MemoryTiles, 0))
+	cache.OnProgress = printProgress
 	for y := y0; y <= y1; y++ {
 		for x := x0; x <= x1; x++ {
 			cache.EnsureTile(Z, x, y)
 		}
 	}
-	cache.Wait()
+	if err := cache.Wait(); err != nil {
+		panic(err)
+	}
 
 	// {
 	// 	const TileSize = terrarium.TileSize
@@ -149,12 +160,26 @@ func main() {
 	// 	return
 	// }
 
+	// voids are filled on a mosaic of every tile so that holes crossing
+	// tile edges are filled from all their sides
+	fmt.Println("filling voids...")
+	mosaic, err := cache.Grid(Z, terrarium.Bounds{
+		terrarium.TileLatLng(Z, x0, y1), terrarium.TileLatLng(Z, x1, y0)})
+	if err != nil {
+		panic(err)
+	}
+	filled := terrarium.FillVoids(mosaic.Values, mosaic.W, mosaic.H, terrarium.FillOptions{
+		Method:      terrarium.FillLaplace,
+		MaxHoleSize: MaxVoidSize,
+	})
+	fmt.Printf("%d pixels filled\n", filled)
+
 	fmt.Println("processing tiles...")
 	jobs := make(chan job, n)
 	results := make(chan result, n)
 	wn := runtime.NumCPU()
 	for wi := 0; wi < wn; wi++ {
-		go worker(cache, jobs, results)
+		go worker(cache, mosaic, jobs, results)
 	}
 	for y := y0; y < y1; y++ {
 		for x := x0; x < x1; x++ {
@@ -162,19 +187,32 @@ func main() {
 		}
 	}
 	close(jobs)
-	var paths []terrarium.Path
+	var fragments []terrarium.Fragment
 	h := make(histogram)
 	for i := 0; i < n; i++ {
 		r := <-results
-		paths = append(paths, r.Paths...)
+		fragments = append(fragments, r.Fragments...)
 		h.Update(r.Histogram)
 	}
 	h.Print()
 
+	fmt.Println("joining paths...")
+	contours := terrarium.JoinContours(fragments, levels)
+	var paths, index []terrarium.Path
+	for _, c := range contours {
+		if c.Index {
+			index = append(index, c.Path)
+		} else {
+			paths = append(paths, c.Path)
+		}
+	}
+	fmt.Printf("%d fragments joined into %d paths\n", len(fragments), len(contours))
+
 	fmt.Println("projecting paths...")
 	proj := maps.NewMercatorProjection()
 	proj.InvertY = true
-	for _, path := range paths {
+	for _, c := range contours {
+		path := c.Path
 		for i, p := range path {
 			q := proj.Project(maps.Point(p))
 			path[i].X = q.X
@@ -193,13 +231,13 @@ func main() {
 	}
 
 	fmt.Println("rendering image...")
-	im := renderPaths(paths, Size, Padding, LineWidth)
+	im := renderPaths(paths, index, Size, Padding, LineWidth)
 
 	fmt.Println("writing png...")
 	gg.SavePNG("out.png", im)
 
 	// fmt.Println("writing axi...")
-	// saveAxi("out.axi", paths)
+	// saveAxi("out.axi", append(paths, index...))
 }
 
 func saveAxi(filename string, paths []terrarium.Path) error {
@@ -227,7 +265,7 @@ type job struct {
 }
 
 type result struct {
-	Paths     []terrarium.Path
+	Fragments []terrarium.Fragment
 	Histogram histogram
 }
 
@@ -251,35 +289,34 @@ func (h histogram) Print() {
 	fmt.Println(total)
 }
 
-func worker(cache *terrarium.Cache, in chan job, out chan result) {
+func worker(cache *terrarium.Cache, mosaic *terrarium.Grid, in chan job, out chan result) {
 	for j := range in {
 		tile, err := cache.GetStitchedTile(j.Z, j.X, j.Y)
 		if err != nil {
 			panic(err)
 		}
+		tile.FillVoidsFromGrid(mosaic)
 		tile.MaskShapes(j.Shapes)
-		var paths []terrarium.Path
-		for z := -10000; z < 10000; z += Step {
-			p := tile.MaskedContourLines(float64(z + 1))
-			paths = append(paths, p...)
-		}
+		fragments := tile.MaskedContourFragments(levels.Values()...)
 		h := make(histogram)
 		for _, e := range tile.MaskedElevation() {
-			if e < tile.MinElevation {
+			if terrarium.IsNoData(e) {
 				continue
 			}
 			h[int(e/HistogramStep)*HistogramStep]++
 		}
-		out <- result{paths, h}
+		out <- result{fragments, h}
 	}
 }
 
-func renderPaths(paths []terrarium.Path, size, pad int, lw float64) image.Image {
-	x0 := paths[0][0].X
-	x1 := paths[0][0].X
-	y0 := paths[0][0].Y
-	y1 := paths[0][0].Y
-	for _, path := range paths {
+// renderPaths draws the paths, with the index paths twice as thick.
+func renderPaths(paths, index []terrarium.Path, size, pad int, lw float64) image.Image {
+	all := append(append([]terrarium.Path(nil), paths...), index...)
+	x0 := all[0][0].X
+	x1 := all[0][0].X
+	y0 := all[0][0].Y
+	y1 := all[0][0].Y
+	for _, path := range all {
 		for _, p := range path {
 			if p.X < x0 {
 				x0 = p.X
@@ -315,9 +352,26 @@ func renderPaths(paths []terrarium.Path, size, pad int, lw float64) image.Image
 	dc.SetRGB(0, 0, 0)
 	dc.SetLineWidth(lw)
 	dc.Stroke()
+	for _, path := range index {
+		dc.NewSubPath()
+		for _, p := range path {
+			dc.LineTo(p.X, p.Y)
+		}
+	}
+	dc.SetLineWidth(lw * 2)
+	dc.Stroke()
 	// dc.Identity()
 	// dc.DrawCircle(float64(dc.Width()/2), float64(dc.Height()/2), 8)
 	// dc.SetRGBA(1, 0, 0, 0.9)
 	// dc.Fill()
 	return dc.Image()
 }
+
+func printProgress(e terrarium.ProgressEvent) {
+	switch e.Status {
+	case terrarium.TileDownloaded:
+		fmt.Printf("%d/%d/%d (%d remaining)\n", e.Z, e.X, e.Y, e.Progress.Pending())
+	case terrarium.TileFailed:
+		fmt.Printf("%d/%d/%d failed: %v\n", e.Z, e.X, e.Y, e.Err)
+	}
+}
@@ -1,90 +1,251 @@
 package terrarium
 
-import "github.com/fogleman/fauxgl"
+import (
+	"math"
+	"sort"
 
-func intersectSegment(z float64, v0, v1 fauxgl.Vector) (fauxgl.Vector, bool) {
-	if v0.Z == v1.Z {
-		return fauxgl.Vector{}, false
+	"github.com/fogleman/fauxgl"
+)
+
+// sample is a vertex of the triangulated grid: either a grid value or the
+// averaged value at the centre of a cell.
+type sample struct {
+	V  fauxgl.Vector
+	ID vertexID
+}
+
+// above reports whether v is above the level z. Levels are handled exactly
+// using symbolic perturbation: a value equal to the level counts as below
+// it, as if the level were raised by an infinitesimal amount. Every sample
+// is then strictly above or below the level, so each edge is crossed at most
+// once and each triangle or cell has a well defined set of segments. A
+// crossing next to a sample that equals the level lies on that sample, but
+// it keeps the identity of its edge, so the traced lines stay topologically
+// closed rings.
+func above(v, z float64) bool {
+	return v > z
+}
+
+// intersectSegment returns where the level z crosses the edge between two
+// samples, along with the identity of that edge.
+func intersectSegment(z float64, s0, s1 sample) (Point, edgeID, bool) {
+	v0, v1 := s0.V, s1.V
+	if above(v0.Z, z) == above(v1.Z, z) {
+		return Point{}, edgeID{}, false
 	}
 	t := (z - v0.Z) / (v1.Z - v0.Z)
-	if t < 0 || t > 1 {
-		return fauxgl.Vector{}, false
-	}
 	v := v0.Add(v1.Sub(v0).MulScalar(t))
-	return v, true
-}
-
-func intersectTriangle(z float64, pt1, pt2, pt3 fauxgl.Vector) (fauxgl.Vector, fauxgl.Vector, bool) {
-	v1, ok1 := intersectSegment(z, pt1, pt2)
-	v2, ok2 := intersectSegment(z, pt2, pt3)
-	v3, ok3 := intersectSegment(z, pt3, pt1)
-	var p1, p2 fauxgl.Vector
-	if ok1 && ok2 {
-		p1, p2 = v1, v2
-	} else if ok1 && ok3 {
-		p1, p2 = v1, v3
-	} else if ok2 && ok3 {
-		p1, p2 = v2, v3
-	} else {
-		return fauxgl.Vector{}, fauxgl.Vector{}, false
-	}
-	n := fauxgl.Vector{p1.Y - p2.Y, p2.X - p1.X, 0}
-	e1 := pt2.Sub(pt1)
-	e2 := pt3.Sub(pt1)
-	tn := e1.Cross(e2).Normalize()
-	if n.Dot(tn) < 0 {
-		return p1, p2, true
-	} else {
-		return p2, p1, true
+	return Point{v.X, v.Y}, makeEdgeID(s0.ID, s1.ID), true
+}
+
+// intersectTriangle returns the segment where the level crosses the
+// triangle, oriented with higher ground on the left when y points down.
+// The orientation only depends on which side of the level each vertex is
+// and on the winding of the triangle, so it is well defined even when the
+// segment has zero length.
+func intersectTriangle(z float64, s1, s2, s3 sample) (pair, bool) {
+	a1, a2, a3 := above(s1.V.Z, z), above(s2.V.Z, z), above(s3.V.Z, z)
+	// rotate the vertices so that s1 is on its own side of the level
+	switch {
+	case a1 != a2 && a1 != a3:
+	case a2 != a1 && a2 != a3:
+		s1, s2, s3 = s2, s3, s1
+	case a3 != a1 && a3 != a2:
+		s1, s2, s3 = s3, s1, s2
+	default:
+		return pair{}, false
 	}
+	p, kp, _ := intersectSegment(z, s1, s2)
+	q, kq, _ := intersectSegment(z, s3, s1)
+	e1 := s2.V.Sub(s1.V)
+	e2 := s3.V.Sub(s1.V)
+	winding := e1.X*e2.Y - e1.Y*e2.X
+	if (winding < 0) == above(s1.V.Z, z) {
+		return pair{p, q, kp, kq}, true
+	}
+	return pair{q, p, kq, kp}, true
 }
 
-func slice(grid []float64, w, h int, z float64) []pair {
-	var result []pair
+// cell holds the values at the corners of the grid cell whose top left
+// corner is X, Y, in the order top left, top right, bottom left, bottom
+// right.
+type cell struct {
+	X, Y int
+	Z    [4]float64
+}
+
+func (c cell) samples() (v0, v1, v2, v3 sample) {
+	fx, fy := float64(c.X), float64(c.Y)
+	v0 = sample{fauxgl.Vector{fx, fy, c.Z[0]}, vertexID{c.X, c.Y, false}}
+	v1 = sample{fauxgl.Vector{fx + 1, fy, c.Z[1]}, vertexID{c.X + 1, c.Y, false}}
+	v2 = sample{fauxgl.Vector{fx, fy + 1, c.Z[2]}, vertexID{c.X, c.Y + 1, false}}
+	v3 = sample{fauxgl.Vector{fx + 1, fy + 1, c.Z[3]}, vertexID{c.X + 1, c.Y + 1, false}}
+	return
+}
+
+func sliceTriangles(result []pair, c cell, z float64) []pair {
+	z0, z1, z2, z3 := c.Z[0], c.Z[1], c.Z[2], c.Z[3]
+	a0, a1, a2, a3 := above(z0, z), above(z1, z), above(z2, z), above(z3, z)
+	if a0 == a1 && a1 == a2 && a2 == a3 {
+		return result
+	}
+	z4 := (z0 + z1 + z2 + z3) / 4
+	v0, v1, v2, v3 := c.samples()
+	v4 := sample{fauxgl.Vector{float64(c.X) + 0.5, float64(c.Y) + 0.5, z4}, vertexID{c.X, c.Y, true}}
+	if p, ok := intersectTriangle(z, v0, v2, v4); ok {
+		result = append(result, p)
+	}
+	if p, ok := intersectTriangle(z, v0, v4, v1); ok {
+		result = append(result, p)
+	}
+	if p, ok := intersectTriangle(z, v1, v4, v3); ok {
+		result = append(result, p)
+	}
+	if p, ok := intersectTriangle(z, v2, v3, v4); ok {
+		result = append(result, p)
+	}
+	return result
+}
+
+// sliceLevels contours the grid at every level in a single pass over its
+// cells, returning the segments for each level in the order given.
+func sliceLevels(method ContourMethod, grid []float64, w, h int, levels []float64) [][]pair {
+	sliceCell := sliceTriangles
+	if method == MarchingSquares {
+		sliceCell = sliceMarching
+	}
+	// NoData levels are left empty
+	var order []int
+	for i, z := range levels {
+		if !IsNoData(z) {
+			order = append(order, i)
+		}
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return levels[order[i]] < levels[order[j]]
+	})
+	sorted := make([]float64, len(order))
+	for i, k := range order {
+		sorted[i] = levels[k]
+	}
+	result := make([][]pair, len(levels))
 	for y := 0; y < h-1; y++ {
-		fy := float64(y)
 		i0 := y * w
 		i1 := i0 + w
 		for x := 0; x < w-1; x++ {
-			fx := float64(x)
-			z0 := grid[i0+x]
-			z1 := grid[i0+x+1]
-			z2 := grid[i1+x]
-			z3 := grid[i1+x+1]
-			if z0 < z && z1 < z && z2 < z && z3 < z {
-				continue
+			c := cell{x, y, [4]float64{grid[i0+x], grid[i0+x+1], grid[i1+x], grid[i1+x+1]}}
+			lo, hi := c.Z[0], c.Z[0]
+			valid := true
+			for _, v := range c.Z {
+				if IsNoData(v) {
+					valid = false
+					break
+				}
+				lo = math.Min(lo, v)
+				hi = math.Max(hi, v)
 			}
-			if z0 > z && z1 > z && z2 > z && z3 > z {
+			if !valid {
 				continue
 			}
-			z4 := (z0 + z1 + z2 + z3) / 4
-			v0 := fauxgl.Vector{fx, fy, z0}
-			v1 := fauxgl.Vector{fx + 1, fy, z1}
-			v2 := fauxgl.Vector{fx, fy + 1, z2}
-			v3 := fauxgl.Vector{fx + 1, fy + 1, z3}
-			v4 := fauxgl.Vector{fx + 0.5, fy + 0.5, z4}
-			if p1, p2, ok := intersectTriangle(z, v0, v2, v4); ok {
-				pair := pair{Point{p1.X, p1.Y}, Point{p2.X, p2.Y}}
-				result = append(result, pair)
-			}
-			if p1, p2, ok := intersectTriangle(z, v0, v4, v1); ok {
-				pair := pair{Point{p1.X, p1.Y}, Point{p2.X, p2.Y}}
-				result = append(result, pair)
-			}
-			if p1, p2, ok := intersectTriangle(z, v1, v4, v3); ok {
-				pair := pair{Point{p1.X, p1.Y}, Point{p2.X, p2.Y}}
-				result = append(result, pair)
-			}
-			if p1, p2, ok := intersectTriangle(z, v2, v3, v4); ok {
-				pair := pair{Point{p1.X, p1.Y}, Point{p2.X, p2.Y}}
-				result = append(result, pair)
+			for i := sort.SearchFloat64s(sorted, lo); i < len(sorted) && sorted[i] <= hi; i++ {
+				k := order[i]
+				result[k] = sliceCell(result[k], c, levels[k])
 			}
 		}
 	}
 	return result
 }
 
+func slice(grid []float64, w, h int, z float64) []pair {
+	return sliceLevels(Triangles, grid, w, h, []float64{z})[0]
+}
+
 func Slice(grid []float64, w, h int, z float64) []Path {
 	pairs := slice(grid, w, h, z)
 	return joinPairs(pairs)
 }
+
+// SliceLevels contours the grid at many levels in a single pass, returning
+// the paths for each level in the order given.
+func SliceLevels(grid []float64, w, h int, levels []float64) [][]Path {
+	return SliceLevelsWith(Triangles, grid, w, h, levels)
+}
+
+func SliceLevelsWith(method ContourMethod, grid []float64, w, h int, levels []float64) [][]Path {
+	return pairsToLevels(sliceLevels(method, grid, w, h, levels))
+}
+
+type Side int
+
+const (
+	Left Side = iota
+	Right
+)
+
+// Contour is a contour line along with its level. Uphill is the side of
+// the path with higher ground when walking from its first point to its
+// last, as seen on a north up map for lat/lng paths or on the image for
+// pixel paths. Length is in meters for lat/lng paths and in pixels
+// otherwise.
+type Contour struct {
+	Level  float64
+	Index  bool
+	Closed bool
+	Uphill Side
+	Length float64
+	Path   Path
+}
+
+// Reverse reverses the direction of the path, which flips the uphill side.
+func (c *Contour) Reverse() {
+	for i, j := 0, len(c.Path)-1; i < j; i, j = i+1, j-1 {
+		c.Path[i], c.Path[j] = c.Path[j], c.Path[i]
+	}
+	c.Uphill = 1 - c.Uphill
+}
+
+// SliceContours contours the grid at each of the levels in a single pass.
+// Paths are in pixel coordinates.
+func SliceContours(method ContourMethod, grid []float64, w, h int, levels Levels) []Contour {
+	pairs := sliceLevels(method, grid, w, h, levels.Values())
+	var fragments []Fragment
+	for i, level := range levels {
+		fragments = append(fragments, pairsToFragments(pairs[i], level.Value)...)
+	}
+	return fragmentsToContours(JoinFragments(fragments), levels, pathLength)
+}
+
+// JoinContours joins tile fragments, as JoinFragments does, and returns
+// them as contours tagged with the matching levels.
+func JoinContours(fragments []Fragment, levels Levels) []Contour {
+	return fragmentsToContours(JoinFragments(fragments), levels, geoPathLength)
+}
+
+func fragmentsToContours(fragments []Fragment, levels Levels, length func(Path) float64) []Contour {
+	index := make(map[float64]bool, len(levels))
+	for _, level := range levels {
+		index[level.Value] = level.Index
+	}
+	contours := make([]Contour, len(fragments))
+	for i, f := range fragments {
+		// the slicers always trace with higher ground on the left
+		contours[i] = Contour{f.Level, index[f.Level], f.Closed(), Left, length(f.Path), f.Path}
+	}
+	return contours
+}
+
+func pathLength(path Path) float64 {
+	var length float64
+	for i := 1; i < len(path); i++ {
+		length += path[i-1].Distance(path[i])
+	}
+	return length
+}
+
+func geoPathLength(path Path) float64 {
+	var length float64
+	for i := 1; i < len(path); i++ {
+		length += HaversineDistance(path[i-1], path[i])
+	}
+	return length
+}
@@ -0,0 +1,183 @@
+package terrarium
+
+import (
+	"errors"
+	"fmt"
+	"image/png"
+	"os"
+	"sort"
+)
+
+var ErrOffline = errors.New("tile not cached and cache is offline")
+
+// TileRange is an inclusive range of tiles at a single zoom level.
+type TileRange struct {
+	Z, X0, Y0, X1, Y1 int
+}
+
+// TileRangeForBounds returns the tiles covering a lat/lng bounds at zoom z.
+func TileRangeForBounds(z int, bounds Bounds) TileRange {
+	p0 := TileXY(z, bounds.Min)
+	p1 := TileXY(z, bounds.Max)
+	if p1.X < p0.X {
+		p0.X, p1.X = p1.X, p0.X
+	}
+	if p1.Y < p0.Y {
+		p0.Y, p1.Y = p1.Y, p0.Y
+	}
+	n := 1 << uint(z)
+	clamp := func(v int) int {
+		if v < 0 {
+			return 0
+		}
+		if v > n-1 {
+			return n - 1
+		}
+		return v
+	}
+	return TileRange{z, clamp(p0.X), clamp(p0.Y), clamp(p1.X), clamp(p1.Y)}
+}
+
+func (r TileRange) Count() int {
+	return (r.X1 - r.X0 + 1) * (r.Y1 - r.Y0 + 1)
+}
+
+func (r TileRange) Contains(id TileID) bool {
+	return id.Z == r.Z && id.X >= r.X0 && id.X <= r.X1 && id.Y >= r.Y0 && id.Y <= r.Y1
+}
+
+func (r TileRange) Tiles() []TileID {
+	tiles := make([]TileID, 0, r.Count())
+	for y := r.Y0; y <= r.Y1; y++ {
+		for x := r.X0; x <= r.X1; x++ {
+			tiles = append(tiles, TileID{r.Z, x, y})
+		}
+	}
+	return tiles
+}
+
+// MissingTilesError lists the tiles that are needed but not cached.
+type MissingTilesError struct {
+	Tiles []TileID
+}
+
+func (e *MissingTilesError) Error() string {
+	const maxListed = 10
+	msg := fmt.Sprintf("%d tiles missing from cache:", len(e.Tiles))
+	for i, id := range e.Tiles {
+		if i == maxListed {
+			msg += fmt.Sprintf(" ... and %d more", len(e.Tiles)-i)
+			break
+		}
+		msg += fmt.Sprintf(" %d/%d/%d", id.Z, id.X, id.Y)
+	}
+	return msg
+}
+
+type CoverageReport struct {
+	Present []TileID
+	Missing []TileID
+	Corrupt []TileID
+	Bytes   int64
+}
+
+func (report *CoverageReport) Total() int {
+	return len(report.Present) + len(report.Missing) + len(report.Corrupt)
+}
+
+func (report *CoverageReport) Complete() bool {
+	return len(report.Missing) == 0 && len(report.Corrupt) == 0
+}
+
+// Coverage reports which of the tiles covering bounds at zoom z are present,
+// missing or corrupt in the cache directory. With decode set, every present
+// tile is fully decoded to detect corruption; otherwise only the PNG header
+// is checked.
+func (cache *Cache) Coverage(z int, bounds Bounds, decode bool) *CoverageReport {
+	return cache.CoverageTiles(TileRangeForBounds(z, bounds).Tiles(), decode)
+}
+
+func (cache *Cache) CoverageTiles(tiles []TileID, decode bool) *CoverageReport {
+	report := &CoverageReport{}
+	for _, id := range tiles {
+		path := cache.tilePath(id.Z, id.X, id.Y)
+		info, err := os.Stat(path)
+		if err != nil {
+			report.Missing = append(report.Missing, id)
+			continue
+		}
+		report.Bytes += info.Size()
+		if err := checkTileFile(path, decode, cache.tileSize); err != nil {
+			report.Corrupt = append(report.Corrupt, id)
+			continue
+		}
+		report.Present = append(report.Present, id)
+	}
+	return report
+}
+
+// EnsureBounds queues downloads for every tile covering bounds at zoom z.
+// In offline mode nothing is downloaded; instead a *MissingTilesError is
+// returned immediately if any of those tiles are not cached.
+func (cache *Cache) EnsureBounds(z int, bounds Bounds) error {
+	tiles := TileRangeForBounds(z, bounds).Tiles()
+	if cache.offline {
+		var missing []TileID
+		for _, id := range tiles {
+			if _, err := os.Stat(cache.tilePath(id.Z, id.X, id.Y)); err != nil {
+				missing = append(missing, id)
+			}
+		}
+		if len(missing) > 0 {
+			sortTileIDs(missing)
+			return &MissingTilesError{missing}
+		}
+		return nil
+	}
+	for _, id := range tiles {
+		cache.EnsureTile(id.Z, id.X, id.Y)
+	}
+	return nil
+}
+
+// checkTileFile returns an error if the file is not a valid tile image of
+// the given size.
+func checkTileFile(path string, decode bool, size int) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var w, h int
+	if decode {
+		im, err := png.Decode(file)
+		if err != nil {
+			return err
+		}
+		size := im.Bounds().Size()
+		w, h = size.X, size.Y
+	} else {
+		config, err := png.DecodeConfig(file)
+		if err != nil {
+			return err
+		}
+		w, h = config.Width, config.Height
+	}
+	if w != size || h != size {
+		return fmt.Errorf("%s: tile is %dx%d, expected %dx%d", path, w, h, size, size)
+	}
+	return nil
+}
+
+func sortTileIDs(tiles []TileID) {
+	sort.Slice(tiles, func(i, j int) bool {
+		a, b := tiles[i], tiles[j]
+		if a.Z != b.Z {
+			return a.Z < b.Z
+		}
+		if a.Y != b.Y {
+			return a.Y < b.Y
+		}
+		return a.X < b.X
+	})
+}
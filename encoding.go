@@ -0,0 +1,293 @@
+package terrarium
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sort"
+)
+
+// ElevationDecoder converts a tile image into a row-major elevation grid in
+// meters.
+type ElevationDecoder interface {
+	DecodeElevation(im image.Image) []float64
+}
+
+// ElevationEncoder converts a row-major elevation grid in meters into a tile
+// image.
+type ElevationEncoder interface {
+	EncodeElevation(elevation []float64, w, h int) image.Image
+}
+
+type ElevationEncoding interface {
+	ElevationDecoder
+	ElevationEncoder
+}
+
+// encodedSource is implemented by tile sources that know how their tiles
+// are encoded. Sources that don't are assumed to be Terrarium encoded.
+type encodedSource interface {
+	TileEncoding() ElevationEncoding
+}
+
+func sourceEncoding(source TileSource) ElevationEncoding {
+	if s, ok := source.(encodedSource); ok {
+		if e := s.TileEncoding(); e != nil {
+			return e
+		}
+	}
+	return TerrariumEncoding{}
+}
+
+func encodingOrDefault(encoding ElevationEncoding) ElevationEncoding {
+	if encoding == nil {
+		return TerrariumEncoding{}
+	}
+	return encoding
+}
+
+// TerrariumEncoding is the Mapzen / AWS Terrain Tiles "terrarium" format:
+// (r * 256 + g + b / 256) - 32768
+// Transparent and pure black pixels are NoData.
+type TerrariumEncoding struct{}
+
+func (TerrariumEncoding) DecodeElevation(im image.Image) []float64 {
+	rgba, _ := ensureRGBA(im)
+	return imageToElevation(rgba)
+}
+
+func (TerrariumEncoding) EncodeElevation(elevation []float64, w, h int) image.Image {
+	return EncodeTerrarium(elevation, w, h)
+}
+
+// MapboxEncoding is the Mapbox Terrain-RGB format:
+// -10000 + (r * 65536 + g * 256 + b) * 0.1
+// Transparent pixels are NoData.
+type MapboxEncoding struct{}
+
+func (MapboxEncoding) DecodeElevation(im image.Image) []float64 {
+	rgba, _ := ensureRGBA(im)
+	w := rgba.Bounds().Size().X
+	h := rgba.Bounds().Size().Y
+	buf := make([]float64, w*h)
+	index := 0
+	for y := 0; y < h; y++ {
+		i := rgba.PixOffset(0, y)
+		for x := 0; x < w; x++ {
+			r := float64(rgba.Pix[i+0])
+			g := float64(rgba.Pix[i+1])
+			b := float64(rgba.Pix[i+2])
+			if rgba.Pix[i+3] == 0 {
+				buf[index] = NoData
+			} else {
+				buf[index] = -10000 + (r*65536+g*256+b)*0.1
+			}
+			index++
+			i += 4
+		}
+	}
+	return buf
+}
+
+func (MapboxEncoding) EncodeElevation(elevation []float64, w, h int) image.Image {
+	im := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		i := im.PixOffset(0, y)
+		for x := 0; x < w; x++ {
+			if IsNoData(elevation[y*w+x]) {
+				i += 4
+				continue
+			}
+			v := int(math.Round(clamp((elevation[y*w+x]+10000)*10, 0, 1<<24-1)))
+			im.Pix[i+0] = uint8(v >> 16)
+			im.Pix[i+1] = uint8(v >> 8)
+			im.Pix[i+2] = uint8(v)
+			im.Pix[i+3] = 255
+			i += 4
+		}
+	}
+	return im
+}
+
+// NormalEncoding is the AWS Terrain Tiles "normal" format, which stores the
+// surface normal in RGB and a quantized elevation in alpha. Decoding only
+// recovers the quantized elevation. When encoding, normals are computed
+// using MetersPerPixel as the horizontal pixel spacing; if it is zero all
+// normals point straight up. NoData is written as alpha 255, which is past
+// the end of the elevation table, with a zero normal.
+type NormalEncoding struct {
+	MetersPerPixel float64
+}
+
+var normalElevations = func() []float64 {
+	var table []float64
+	for i := 0; i < 11; i++ {
+		table = append(table, float64(-11000+i*1000))
+	}
+	table = append(table, -100, -50, -20, -10, -1)
+	for i := 0; i < 150; i++ {
+		table = append(table, float64(20*i))
+	}
+	for i := 0; i < 60; i++ {
+		table = append(table, float64(3000+50*i))
+	}
+	for i := 0; i < 29; i++ {
+		table = append(table, float64(6000+100*i))
+	}
+	return table
+}()
+
+func (NormalEncoding) DecodeElevation(im image.Image) []float64 {
+	nrgba := ensureNRGBA(im)
+	w := nrgba.Bounds().Size().X
+	h := nrgba.Bounds().Size().Y
+	buf := make([]float64, w*h)
+	index := 0
+	for y := 0; y < h; y++ {
+		i := nrgba.PixOffset(0, y)
+		for x := 0; x < w; x++ {
+			a := int(nrgba.Pix[i+3])
+			if a >= len(normalElevations) {
+				buf[index] = NoData
+			} else {
+				buf[index] = normalElevations[a]
+			}
+			index++
+			i += 4
+		}
+	}
+	return buf
+}
+
+func (encoding NormalEncoding) EncodeElevation(elevation []float64, w, h int) image.Image {
+	im := image.NewNRGBA(image.Rect(0, 0, w, h))
+	at := func(x, y int) float64 {
+		x = clampInt(x, 0, w-1)
+		y = clampInt(y, 0, h-1)
+		return elevation[y*w+x]
+	}
+	// slope across e using whichever neighbours have data
+	slope := func(e0, e, e1 float64) float64 {
+		switch {
+		case !IsNoData(e0) && !IsNoData(e1):
+			return (e1 - e0) / 2
+		case !IsNoData(e1):
+			return e1 - e
+		case !IsNoData(e0):
+			return e - e0
+		}
+		return 0
+	}
+	for y := 0; y < h; y++ {
+		i := im.PixOffset(0, y)
+		for x := 0; x < w; x++ {
+			e := elevation[y*w+x]
+			if IsNoData(e) {
+				im.Pix[i+3] = 255
+				i += 4
+				continue
+			}
+			nx, ny, nz := 0.0, 0.0, 1.0
+			if d := encoding.MetersPerPixel; d > 0 {
+				dx := slope(at(x-1, y), e, at(x+1, y)) / d
+				dy := slope(at(x, y-1), e, at(x, y+1)) / d
+				l := math.Sqrt(dx*dx + dy*dy + 1)
+				nx, ny, nz = -dx/l, dy/l, 1/l
+			}
+			a := sort.SearchFloat64s(normalElevations, e)
+			if a == len(normalElevations) || normalElevations[a] > e {
+				a--
+			}
+			im.Pix[i+0] = uint8(math.Round((nx + 1) / 2 * 255))
+			im.Pix[i+1] = uint8(math.Round((ny + 1) / 2 * 255))
+			im.Pix[i+2] = uint8(math.Round((nz + 1) / 2 * 255))
+			im.Pix[i+3] = uint8(clampInt(a, 0, len(normalElevations)-1))
+			i += 4
+		}
+	}
+	return im
+}
+
+// GrayEncoding is a 16-bit grayscale heightmap: value * Scale + Offset. A
+// zero Scale is treated as 1. If HasNoData is set, the raw value NoData
+// marks missing samples in both directions and elevations that would encode
+// to it are nudged by one. Otherwise every raw value is an elevation and
+// missing samples are encoded as zero.
+type GrayEncoding struct {
+	Scale     float64
+	Offset    float64
+	NoData    uint16
+	HasNoData bool
+}
+
+func (encoding GrayEncoding) isNoData(v uint16) bool {
+	return encoding.HasNoData && v == encoding.NoData
+}
+
+func (encoding GrayEncoding) scale() float64 {
+	if encoding.Scale == 0 {
+		return 1
+	}
+	return encoding.Scale
+}
+
+func (encoding GrayEncoding) DecodeElevation(im image.Image) []float64 {
+	scale := encoding.scale()
+	b := im.Bounds()
+	w := b.Size().X
+	h := b.Size().Y
+	buf := make([]float64, w*h)
+	index := 0
+	if gray, ok := im.(*image.Gray16); ok {
+		for y := 0; y < h; y++ {
+			i := gray.PixOffset(b.Min.X, b.Min.Y+y)
+			for x := 0; x < w; x++ {
+				v := uint16(gray.Pix[i])<<8 | uint16(gray.Pix[i+1])
+				if encoding.isNoData(v) {
+					buf[index] = NoData
+				} else {
+					buf[index] = float64(v)*scale + encoding.Offset
+				}
+				index++
+				i += 2
+			}
+		}
+		return buf
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.Gray16Model.Convert(im.At(b.Min.X+x, b.Min.Y+y)).(color.Gray16)
+			if encoding.isNoData(c.Y) {
+				buf[index] = NoData
+			} else {
+				buf[index] = float64(c.Y)*scale + encoding.Offset
+			}
+			index++
+		}
+	}
+	return buf
+}
+
+func (encoding GrayEncoding) EncodeElevation(elevation []float64, w, h int) image.Image {
+	scale := encoding.scale()
+	im := image.NewGray16(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			e := elevation[y*w+x]
+			if IsNoData(e) {
+				im.SetGray16(x, y, color.Gray16{encoding.NoData})
+				continue
+			}
+			v := uint16(clamp(math.Round((e-encoding.Offset)/scale), 0, 65535))
+			if encoding.isNoData(v) {
+				if v < 65535 {
+					v++
+				} else {
+					v--
+				}
+			}
+			im.SetGray16(x, y, color.Gray16{v})
+		}
+	}
+	return im
+}
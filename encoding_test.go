@@ -0,0 +1,117 @@
+package terrarium
+
+import (
+	"errors"
+	"image"
+	"math"
+	"testing"
+)
+
+func TestNormalEncodingNoData(t *testing.T) {
+	encoding := NormalEncoding{30}
+	elevation := []float64{
+		100, 120, 140,
+		100, NoData, 140,
+		100, 120, 140,
+	}
+	im := encoding.EncodeElevation(elevation, 3, 3).(*image.NRGBA)
+	for i := 0; i < len(im.Pix); i += 4 {
+		if i == 4*4 {
+			continue
+		}
+		r, g, b := im.Pix[i], im.Pix[i+1], im.Pix[i+2]
+		if r == 0 && g == 0 && b == 0 {
+			t.Errorf("pixel %d: missing normal next to NoData", i/4)
+		}
+	}
+	got := encoding.DecodeElevation(im)
+	for i, e := range elevation {
+		if IsNoData(e) != IsNoData(got[i]) {
+			t.Errorf("pixel %d: decoded %g from %g", i, got[i], e)
+		}
+		if !IsNoData(e) && math.Abs(got[i]-e) > 20 {
+			t.Errorf("pixel %d: decoded %g from %g", i, got[i], e)
+		}
+	}
+}
+
+func TestGrayEncodingNoData(t *testing.T) {
+	for _, encoding := range []GrayEncoding{{1, 0, 0, true}, {0.5, -100, 65535, true}} {
+		elevation := []float64{NoData, 5, encoding.Offset}
+		got := encoding.DecodeElevation(encoding.EncodeElevation(elevation, 3, 1))
+		if !IsNoData(got[0]) {
+			t.Errorf("%v: decoded %g from NoData", encoding, got[0])
+		}
+		if got[1] != 5 {
+			t.Errorf("%v: decoded %g from 5", encoding, got[1])
+		}
+		// the lowest elevation collides with NoData 0 and is nudged up
+		if IsNoData(got[2]) {
+			t.Errorf("%v: decoded NoData from %g", encoding, elevation[2])
+		}
+	}
+}
+
+func TestGrayEncodingWithoutNoData(t *testing.T) {
+	encoding := GrayEncoding{}
+	elevation := []float64{0, 5, 65535}
+	got := encoding.DecodeElevation(encoding.EncodeElevation(elevation, 3, 1))
+	for i, e := range elevation {
+		if got[i] != e {
+			t.Errorf("decoded %g from %g", got[i], e)
+		}
+	}
+}
+
+func TestSourceChainEncoding(t *testing.T) {
+	elevation := []float64{-50, 0, 1234.5, 8000}
+	terrarium := NewMemorySource()
+	mapbox := NewMemorySource()
+	mapbox.Encoding = MapboxEncoding{}
+	mapbox.SetTile(0, 0, 0, MapboxEncoding{}.EncodeElevation(elevation, 2, 2))
+	chain := SourceChain{terrarium, mapbox}
+	tile, err := GetTile(chain, 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, e := range elevation {
+		x, y := i%2, i/2
+		if got := tile.Elevation[i]; math.Abs(got-e) > 0.1 {
+			t.Errorf("(%d, %d): got %g, want %g", x, y, got, e)
+		}
+	}
+}
+
+// tableEncoding is Terrarium encoding with a lookup table, which makes it
+// impossible to compare with ==.
+type tableEncoding struct {
+	TerrariumEncoding
+	table []float64
+}
+
+type brokenSource struct{}
+
+func (brokenSource) TileImage(z, x, y int) (image.Image, error) {
+	return nil, errors.New("corrupt tile")
+}
+
+func TestSourceChainErrors(t *testing.T) {
+	empty := NewMemorySource()
+	empty.Encoding = tableEncoding{table: []float64{1}}
+	table := NewMemorySource()
+	table.Encoding = tableEncoding{table: []float64{1}}
+	table.SetTile(0, 0, 0, EncodeTerrarium(make([]float64, 4), 2, 2))
+	chain := SourceChain{empty, table}
+	if _, err := chain.TileImage(0, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	chain = SourceChain{brokenSource{}, table}
+	if _, err := chain.TileImage(0, 0, 0); err == nil || isNotFound(err) {
+		t.Errorf("got %v, want the first source's error", err)
+	}
+	chain = SourceChain{NewMemorySource(), NewMemorySource()}
+	if _, err := chain.TileImage(0, 0, 0); !isNotFound(err) {
+		t.Errorf("got %v, want ErrTileNotFound", err)
+	}
+}
@@ -0,0 +1,58 @@
+package terrarium
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type TileError struct {
+	TileID
+	Err error
+}
+
+func (e *TileError) Error() string {
+	return fmt.Sprintf("tile %d/%d/%d: %v", e.Z, e.X, e.Y, e.Err)
+}
+
+func (e *TileError) Unwrap() error {
+	return e.Err
+}
+
+// TileErrors collects the errors for every tile that failed in a batch.
+type TileErrors []*TileError
+
+func (errs TileErrors) Error() string {
+	const maxListed = 10
+	var lines []string
+	for i, e := range errs {
+		if i == maxListed {
+			lines = append(lines, fmt.Sprintf("... and %d more", len(errs)-i))
+			break
+		}
+		lines = append(lines, e.Error())
+	}
+	return fmt.Sprintf("%d tiles failed:\n%s", len(errs), strings.Join(lines, "\n"))
+}
+
+// StatusError is returned when a tile server responds with a non-2xx status.
+type StatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("GET %s: %d %s", e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+func isNotFound(err error) bool {
+	return errors.Is(err, ErrTileNotFound)
+}
+
+func isRetryable(err error) bool {
+	if e, ok := err.(*StatusError); ok {
+		return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+	}
+	return true
+}
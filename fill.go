@@ -0,0 +1,219 @@
+package terrarium
+
+import "math"
+
+type FillMethod int
+
+const (
+	// FillNearest copies the value of the nearest valid pixel.
+	FillNearest FillMethod = iota
+	// FillIDW uses inverse-distance-weighting of the pixels around the hole.
+	FillIDW
+	// FillLaplace solves Laplace's equation over the hole, giving the
+	// smoothest surface that matches the pixels around it.
+	FillLaplace
+)
+
+type FillOptions struct {
+	Method FillMethod
+	// MaxHoleSize is the largest hole, in pixels, that will be filled.
+	// Zero means no limit.
+	MaxHoleSize int
+	// Power is the IDW distance exponent. The default is 2.
+	Power float64
+	// Iterations limits the Laplace relaxation. The default is 1000.
+	Iterations int
+	// Tolerance stops the Laplace relaxation once no pixel changes by more
+	// than this many meters. The default is 0.01.
+	Tolerance float64
+}
+
+// FillVoids fills NoData holes in a row-major w x h elevation grid in place
+// and returns the number of pixels filled.
+func FillVoids(grid []float64, w, h int, options FillOptions) int {
+	if options.Power == 0 {
+		options.Power = 2
+	}
+	if options.Iterations == 0 {
+		options.Iterations = 1000
+	}
+	if options.Tolerance == 0 {
+		options.Tolerance = 0.01
+	}
+	filled := 0
+	seen := make([]bool, len(grid))
+	for i, e := range grid {
+		if seen[i] || !IsNoData(e) {
+			continue
+		}
+		hole, border := findHole(grid, w, h, i, seen)
+		if len(border) == 0 {
+			continue
+		}
+		if options.MaxHoleSize > 0 && len(hole) > options.MaxHoleSize {
+			continue
+		}
+		switch options.Method {
+		case FillNearest:
+			fillNearest(grid, w, h, hole, border)
+		case FillIDW:
+			fillIDW(grid, w, hole, border, options.Power)
+		case FillLaplace:
+			fillIDW(grid, w, hole, border, options.Power)
+			fillLaplace(grid, w, h, hole, options.Iterations, options.Tolerance)
+		}
+		filled += len(hole)
+	}
+	return filled
+}
+
+// FillVoids fills holes in the tile's elevation and updates Valid,
+// MinElevation and MaxElevation to match.
+func (tile *Tile) FillVoids(options FillOptions) int {
+	n := FillVoids(tile.Elevation, tile.W, tile.H, options)
+	if n > 0 {
+		tile.Valid = validMask(tile.Elevation, tile.W, tile.H)
+		tile.MinElevation, tile.MaxElevation = elevationRange(tile.Elevation)
+	}
+	return n
+}
+
+// FillVoidsFromGrid copies the grid's values into the tile's NoData pixels.
+// The grid should be at the tile's zoom, such as a MosaicGrid around the
+// tile with its voids filled, so that holes crossing tile edges are filled
+// using the data on all their sides. It returns the number of pixels
+// filled.
+func (tile *Tile) FillVoidsFromGrid(grid *Grid) int {
+	inverse := grid.Transform.Inverse()
+	n := 0
+	for y := 0; y < tile.H; y++ {
+		for x := 0; x < tile.W; x++ {
+			i := y*tile.W + x
+			if !IsNoData(tile.Elevation[i]) {
+				continue
+			}
+			p := tile.PixelToLatLng(Point{float64(x), float64(y)})
+			q := inverse.Apply(latLngToMercator(p))
+			gx, gy := int(math.Round(q.X)), int(math.Round(q.Y))
+			if gx < 0 || gy < 0 || gx >= grid.W || gy >= grid.H || !grid.IsValid(gx, gy) {
+				continue
+			}
+			tile.Elevation[i] = grid.At(gx, gy)
+			n++
+		}
+	}
+	if n > 0 {
+		tile.Valid = validMask(tile.Elevation, tile.W, tile.H)
+		tile.MinElevation, tile.MaxElevation = elevationRange(tile.Elevation)
+	}
+	return n
+}
+
+var neighbors4 = [][2]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}}
+
+// findHole flood fills the 4-connected NoData region containing start. It
+// returns the indexes of the hole and of the valid pixels bordering it.
+func findHole(grid []float64, w, h, start int, seen []bool) ([]int, []int) {
+	var hole, border []int
+	isBorder := make(map[int]bool)
+	queue := []int{start}
+	seen[start] = true
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+		hole = append(hole, i)
+		x, y := i%w, i/w
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				nx, ny := x+dx, y+dy
+				if nx < 0 || ny < 0 || nx >= w || ny >= h || (dx == 0 && dy == 0) {
+					continue
+				}
+				j := ny*w + nx
+				if !IsNoData(grid[j]) {
+					if !isBorder[j] {
+						isBorder[j] = true
+						border = append(border, j)
+					}
+				} else if !seen[j] && (dx == 0 || dy == 0) {
+					seen[j] = true
+					queue = append(queue, j)
+				}
+			}
+		}
+	}
+	return hole, border
+}
+
+func fillNearest(grid []float64, w, h int, hole, border []int) {
+	inHole := make(map[int]bool, len(hole))
+	for _, i := range hole {
+		inHole[i] = true
+	}
+	// breadth first search outward from the border, so each hole pixel
+	// takes the value of the border pixel that reached it first
+	queue := append([]int(nil), border...)
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+		x, y := i%w, i/w
+		for _, d := range neighbors4 {
+			nx, ny := x+d[0], y+d[1]
+			if nx < 0 || ny < 0 || nx >= w || ny >= h {
+				continue
+			}
+			j := ny*w + nx
+			if inHole[j] {
+				delete(inHole, j)
+				grid[j] = grid[i]
+				queue = append(queue, j)
+			}
+		}
+	}
+}
+
+func fillIDW(grid []float64, w int, hole, border []int, power float64) {
+	values := make([]float64, len(hole))
+	for k, i := range hole {
+		x, y := float64(i%w), float64(i/w)
+		var sum, total float64
+		for _, j := range border {
+			dx := float64(j%w) - x
+			dy := float64(j/w) - y
+			weight := 1 / math.Pow(dx*dx+dy*dy, power/2)
+			sum += grid[j] * weight
+			total += weight
+		}
+		values[k] = sum / total
+	}
+	for k, i := range hole {
+		grid[i] = values[k]
+	}
+}
+
+func fillLaplace(grid []float64, w, h int, hole []int, iterations int, tolerance float64) {
+	// successive over-relaxation with the border pixels held fixed
+	const omega = 1.8
+	for iteration := 0; iteration < iterations; iteration++ {
+		maxDelta := 0.0
+		for _, i := range hole {
+			x, y := i%w, i/w
+			var sum float64
+			var n int
+			for _, d := range neighbors4 {
+				nx, ny := x+d[0], y+d[1]
+				if nx < 0 || ny < 0 || nx >= w || ny >= h {
+					continue
+				}
+				sum += grid[ny*w+nx]
+				n++
+			}
+			delta := omega * (sum/float64(n) - grid[i])
+			grid[i] += delta
+			maxDelta = math.Max(maxDelta, math.Abs(delta))
+		}
+		if maxDelta < tolerance {
+			break
+		}
+	}
+}
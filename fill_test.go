@@ -0,0 +1,55 @@
+package terrarium
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFillVoidsAcrossTileEdge(t *testing.T) {
+	// a ramp with a hole straddling the edge between tiles 0 and 1
+	source := NewMemorySource()
+	size := 16
+	for tx := 0; tx < 2; tx++ {
+		e := make([]float64, size*size)
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				gx := tx*size + x
+				if gx >= 13 && gx <= 18 && y >= 6 && y <= 9 {
+					e[y*size+x] = NoData
+				} else {
+					e[y*size+x] = float64(gx)
+				}
+			}
+		}
+		source.SetTile(1, tx, 0, EncodeTerrarium(e, size, size))
+	}
+	mosaic, err := MosaicGrid(source, 1, Bounds{
+		PixelToLatLng(1, size, Point{0, 15}), PixelToLatLng(1, size, Point{31, 0})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := FillOptions{Method: FillLaplace, Tolerance: 1e-6, Iterations: 10000}
+	if n := FillVoids(mosaic.Values, mosaic.W, mosaic.H, options); n != 24 {
+		t.Fatalf("filled %d mosaic pixels, want 24", n)
+	}
+	for tx := 0; tx < 2; tx++ {
+		tile, err := GetTile(source, 1, tx, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := tile.FillVoidsFromGrid(mosaic); n != 12 {
+			t.Errorf("tile %d: filled %d pixels, want 12", tx, n)
+		}
+		if tile.Valid != nil {
+			t.Errorf("tile %d: Valid was not cleared", tx)
+		}
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				want := float64(tx*size + x)
+				if got := tile.Elevation[y*size+x]; math.Abs(got-want) > 0.05 {
+					t.Errorf("tile %d (%d, %d): got %g, want %g", tx, x, y, got, want)
+				}
+			}
+		}
+	}
+}
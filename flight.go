@@ -0,0 +1,102 @@
+package terrarium
+
+import (
+	"context"
+	"sync"
+)
+
+// flightGroup coalesces concurrent work for the same tile so that it is only
+// performed once, with every caller receiving the same result.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[TileID]*flightCall
+}
+
+type flightCall struct {
+	done chan struct{}
+	val  interface{}
+	err  error
+
+	// ctx is cancelled once every caller that joined the call has gone
+	ctx    context.Context
+	cancel context.CancelFunc
+	refs   int
+
+	// collect is set if any caller wants a failure collected for Wait
+	collect bool
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: make(map[TileID]*flightCall)}
+}
+
+// begin returns the call already in flight for id, or registers and returns
+// a new call with leader set. The leader must eventually call finish. Each
+// caller holds a reference to the call, see watch.
+func (g *flightGroup) begin(id TileID) (call *flightCall, leader bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	// a call that every caller has abandoned is replaced rather than joined
+	if call, ok := g.calls[id]; ok && call.ctx.Err() == nil {
+		call.refs++
+		return call, false
+	}
+	call = &flightCall{done: make(chan struct{}), refs: 1}
+	call.ctx, call.cancel = context.WithCancel(context.Background())
+	g.calls[id] = call
+	return call, true
+}
+
+// watch drops the caller's reference to the call if ctx is done before the
+// call is. The call's own context is cancelled once no references remain.
+func (g *flightGroup) watch(ctx context.Context, call *flightCall) {
+	if ctx.Done() == nil {
+		return
+	}
+	go func() {
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			g.mu.Lock()
+			call.refs--
+			if call.refs == 0 {
+				call.cancel()
+			}
+			g.mu.Unlock()
+		}
+	}()
+}
+
+// collectErrors marks the call's failure as wanted by Cache.Wait.
+func (g *flightGroup) collectErrors(call *flightCall) {
+	g.mu.Lock()
+	call.collect = true
+	g.mu.Unlock()
+}
+
+// finish records the result of the call and reports whether any caller
+// asked for a failure to be collected.
+func (g *flightGroup) finish(id TileID, call *flightCall, val interface{}, err error) bool {
+	g.mu.Lock()
+	if g.calls[id] == call {
+		delete(g.calls, id)
+	}
+	collect := call.collect
+	g.mu.Unlock()
+	call.val = val
+	call.err = err
+	close(call.done)
+	call.cancel()
+	return collect
+}
+
+func (g *flightGroup) Do(id TileID, fn func() (interface{}, error)) (interface{}, error) {
+	call, leader := g.begin(id)
+	if !leader {
+		<-call.done
+		return call.val, call.err
+	}
+	val, err := fn()
+	g.finish(id, call, val, err)
+	return val, err
+}
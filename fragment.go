@@ -0,0 +1,103 @@
+package terrarium
+
+// Fragment is a piece of a contour line. Its ends carry the identity of the
+// grid edge they lie on, in global pixel coordinates for tile fragments, so
+// that fragments from neighbouring tiles at the same zoom can be joined
+// exactly with JoinFragments rather than by comparing coordinates.
+type Fragment struct {
+	Level float64
+	Path  Path
+
+	start, end edgeID
+}
+
+// Closed reports whether the fragment is a complete ring.
+func (f Fragment) Closed() bool {
+	return len(f.Path) > 2 && f.start == f.end
+}
+
+type fragmentKey struct {
+	Level float64
+	Edge  edgeID
+}
+
+// JoinFragments merges fragments whose ends meet into continuous paths.
+// Paths that end where they start are closed rings whose first and last
+// points are identical. Fragments at different levels are never joined.
+func JoinFragments(fragments []Fragment) []Fragment {
+	var result []Fragment
+	outgoing := make(map[fragmentKey][]int, len(fragments))
+	incoming := make(map[fragmentKey]int, len(fragments))
+	for i, f := range fragments {
+		start := fragmentKey{f.Level, f.start}
+		outgoing[start] = append(outgoing[start], i)
+		incoming[fragmentKey{f.Level, f.end}]++
+	}
+	used := make([]bool, len(fragments))
+	next := func(key fragmentKey) int {
+		indexes := outgoing[key]
+		for len(indexes) > 0 {
+			i := indexes[0]
+			indexes = indexes[1:]
+			if !used[i] {
+				outgoing[key] = indexes
+				return i
+			}
+		}
+		delete(outgoing, key)
+		return -1
+	}
+	follow := func(i int) Fragment {
+		f := fragments[i]
+		used[i] = true
+		path := appendPoints(nil, f.Path)
+		joined := Fragment{f.Level, nil, f.start, f.end}
+		for joined.start != joined.end {
+			j := next(fragmentKey{joined.Level, joined.end})
+			if j < 0 {
+				break
+			}
+			used[j] = true
+			path = appendPoints(path, fragments[j].Path[1:])
+			joined.end = fragments[j].end
+		}
+		if joined.start == joined.end && len(path) > 1 {
+			path[len(path)-1] = path[0]
+		}
+		joined.Path = path
+		return joined
+	}
+	add := func(f Fragment) {
+		// rings around a single sample equal to the level collapse to a
+		// point and are dropped
+		if f.start == f.end && len(f.Path) < 2 {
+			return
+		}
+		result = append(result, f)
+	}
+	// start with the fragments that nothing leads into so that open lines
+	// are traced from their beginning, then what is left are rings
+	for i, f := range fragments {
+		if !used[i] && incoming[fragmentKey{f.Level, f.start}] == 0 {
+			add(follow(i))
+		}
+	}
+	for i := range fragments {
+		if !used[i] {
+			add(follow(i))
+		}
+	}
+	return result
+}
+
+// appendPoints appends points to a path, skipping repeated points where a
+// contour passes exactly through a sample.
+func appendPoints(path Path, points Path) Path {
+	for _, p := range points {
+		if len(path) > 0 && path[len(path)-1] == p {
+			continue
+		}
+		path = append(path, p)
+	}
+	return path
+}
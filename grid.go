@@ -0,0 +1,165 @@
+package terrarium
+
+import (
+	"image"
+	"math"
+
+	"github.com/fogleman/maps"
+)
+
+const earthCircumference = 2 * math.Pi * 6378137
+
+// Affine is a GDAL style geotransform mapping pixel coordinates to
+// projected coordinates:
+//
+//	X = A[0] + A[1]*x + A[2]*y
+//	Y = A[3] + A[4]*x + A[5]*y
+type Affine [6]float64
+
+func (a Affine) Apply(p Point) Point {
+	return Point{a[0] + a[1]*p.X + a[2]*p.Y, a[3] + a[4]*p.X + a[5]*p.Y}
+}
+
+func (a Affine) Inverse() Affine {
+	det := a[1]*a[5] - a[2]*a[4]
+	b1 := a[5] / det
+	b2 := -a[2] / det
+	b4 := -a[4] / det
+	b5 := a[1] / det
+	return Affine{-b1*a[0] - b2*a[3], b1, b2, -b4*a[0] - b5*a[3], b4, b5}
+}
+
+// Grid is a georeferenced elevation raster. Values are stored row by row
+// and missing values are NoData. Transform maps pixel coordinates to Web
+// Mercator (EPSG:3857) meters; like tile elevations, each value is located
+// at its integer pixel coordinate rather than at the pixel centre.
+type Grid struct {
+	W, H      int
+	Values    []float64
+	Transform Affine
+}
+
+func NewGrid(w, h int, transform Affine) *Grid {
+	values := make([]float64, w*h)
+	for i := range values {
+		values[i] = NoData
+	}
+	return &Grid{w, h, values, transform}
+}
+
+// MosaicGrid builds a single grid from all the tiles of a source covering
+// bounds at zoom z, cropped to the pixels spanning bounds.
+func MosaicGrid(source TileSource, z int, bounds Bounds) (*Grid, error) {
+	r := TileRangeForBounds(z, bounds)
+	first, err := GetTile(source, z, r.X0, r.Y0)
+	if err != nil {
+		return nil, err
+	}
+	size := first.Size
+	p0 := LatLngToPixel(z, size, Point{
+		math.Min(bounds.Min.X, bounds.Max.X), math.Max(bounds.Min.Y, bounds.Max.Y)})
+	p1 := LatLngToPixel(z, size, Point{
+		math.Max(bounds.Min.X, bounds.Max.X), math.Min(bounds.Min.Y, bounds.Max.Y)})
+	// rounding outwards stops at the edge of the tile range, which is all
+	// that EnsureBounds downloads for the same bounds
+	xmax := (r.X1+1)*size - 1
+	ymax := (r.Y1+1)*size - 1
+	px0 := int(clamp(math.Floor(p0.X), float64(r.X0*size), float64(xmax)))
+	py0 := int(clamp(math.Floor(p0.Y), float64(r.Y0*size), float64(ymax)))
+	px1 := int(clamp(math.Ceil(p1.X), float64(px0), float64(xmax)))
+	py1 := int(clamp(math.Ceil(p1.Y), float64(py0), float64(ymax)))
+
+	grid := NewGrid(px1-px0+1, py1-py0+1, mercatorTransform(z, size, px0, py0))
+	for ty := py0 / size; ty <= py1/size; ty++ {
+		for tx := px0 / size; tx <= px1/size; tx++ {
+			tile := first
+			if tx != r.X0 || ty != r.Y0 {
+				tile, err = GetTile(source, z, tx, ty)
+				if err != nil {
+					return nil, err
+				}
+			}
+			x0 := clampInt(px0-tx*size, 0, size-1)
+			x1 := clampInt(px1-tx*size, 0, size-1)
+			y0 := clampInt(py0-ty*size, 0, size-1)
+			y1 := clampInt(py1-ty*size, 0, size-1)
+			for y := y0; y <= y1; y++ {
+				i := y*tile.W + x0
+				j := (ty*size+y-py0)*grid.W + tx*size + x0 - px0
+				copy(grid.Values[j:j+x1-x0+1], tile.Elevation[i:i+x1-x0+1])
+			}
+		}
+	}
+	return grid, nil
+}
+
+func (cache *Cache) Grid(z int, bounds Bounds) (*Grid, error) {
+	return MosaicGrid(cache, z, bounds)
+}
+
+// mercatorTransform returns the transform for a grid whose first pixel is
+// the global pixel (px, py) at zoom z.
+func mercatorTransform(z, size, px, py int) Affine {
+	res := earthCircumference / float64(size<<uint(z))
+	x := float64(px)*res - earthCircumference/2
+	y := earthCircumference/2 - float64(py)*res
+	return Affine{x, res, 0, y, 0, -res}
+}
+
+func mercatorToLatLng(p Point) Point {
+	lng := p.X / earthCircumference * 360
+	lat := math.Atan(math.Sinh(p.Y/earthCircumference*2*math.Pi)) * 180 / math.Pi
+	return Point{lng, lat}
+}
+
+func latLngToMercator(p Point) Point {
+	lat := p.Y * math.Pi / 180
+	x := p.X / 360 * earthCircumference
+	y := math.Log(math.Tan(math.Pi/4+lat/2)) / (2 * math.Pi) * earthCircumference
+	return Point{x, y}
+}
+
+func (grid *Grid) At(x, y int) float64 {
+	return grid.Values[y*grid.W+x]
+}
+
+func (grid *Grid) IsValid(x, y int) bool {
+	return !IsNoData(grid.At(x, y))
+}
+
+// Range returns the minimum and maximum valid values, or NoData if there
+// are none.
+func (grid *Grid) Range() (float64, float64) {
+	return elevationRange(grid.Values)
+}
+
+func (grid *Grid) PixelToLatLng(p Point) Point {
+	return mercatorToLatLng(grid.Transform.Apply(p))
+}
+
+func (grid *Grid) LatLngToPixel(p Point) Point {
+	return grid.Transform.Inverse().Apply(latLngToMercator(p))
+}
+
+// Bounds returns the lat/lng bounds spanned by the grid values.
+func (grid *Grid) Bounds() Bounds {
+	a := grid.PixelToLatLng(Point{0, float64(grid.H - 1)})
+	b := grid.PixelToLatLng(Point{float64(grid.W - 1), 0})
+	return Bounds{a, b}
+}
+
+// MaskShapes sets values outside the shapes to NoData.
+func (grid *Grid) MaskShapes(shapes []maps.Shape) {
+	mask := renderMask(grid.W, grid.H, shapes, grid.LatLngToPixel)
+	for y := 0; y < grid.H; y++ {
+		for x := 0; x < grid.W; x++ {
+			if mask.AlphaAt(x, y).A != 255 {
+				grid.Values[y*grid.W+x] = NoData
+			}
+		}
+	}
+}
+
+func (grid *Grid) AsGray16(lo, hi float64) *image.Gray16 {
+	return elevationToGray16(grid.Values, grid.W, grid.H, lo, hi)
+}
@@ -0,0 +1,24 @@
+package terrarium
+
+import "testing"
+
+func TestMosaicGridStaysInTileRange(t *testing.T) {
+	size := 16
+	source := NewMemorySource()
+	source.SetTile(2, 0, 0, EncodeTerrarium(make([]float64, size*size), size, size))
+	// the bounds end inside the last pixel column and row of the tile
+	bounds := Bounds{
+		PixelToLatLng(2, size, Point{2.5, 15.5}),
+		PixelToLatLng(2, size, Point{15.5, 3.5}),
+	}
+	if r := TileRangeForBounds(2, bounds); r.Count() != 1 {
+		t.Fatalf("bounds cover %d tiles, want 1", r.Count())
+	}
+	grid, err := MosaicGrid(source, 2, bounds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grid.W != 14 || grid.H != 13 {
+		t.Errorf("got a %d x %d grid, want 14 x 13", grid.W, grid.H)
+	}
+}
@@ -0,0 +1,153 @@
+package terrarium
+
+import (
+	"math"
+	"sort"
+)
+
+// Level is a contour level. Index levels are the every-Nth levels that maps
+// usually draw heavier and label; the rest are intermediate.
+type Level struct {
+	Value float64
+	Index bool
+}
+
+// Levels is a list of contour levels in increasing order.
+type Levels []Level
+
+// Values returns the level values, e.g. for SliceLevels.
+func (levels Levels) Values() []float64 {
+	values := make([]float64, len(levels))
+	for i, level := range levels {
+		values[i] = level.Value
+	}
+	return values
+}
+
+// IndexEvery marks every nth level as an index level, starting with the
+// first, and the rest as intermediate.
+func (levels Levels) IndexEvery(n int) Levels {
+	for i := range levels {
+		levels[i].Index = n > 0 && i%n == 0
+	}
+	return levels
+}
+
+// IndexMultiples marks the levels that are multiples of step, e.g. every
+// 100 m, as index levels and the rest as intermediate.
+func (levels Levels) IndexMultiples(step float64) Levels {
+	for i, level := range levels {
+		k := math.Round(level.Value / step)
+		levels[i].Index = step > 0 && math.Abs(level.Value-k*step) < step*1e-9
+	}
+	return levels
+}
+
+// IntervalLevels returns the levels base + k*interval that lie within
+// [lo, hi]. Every indexEvery-th level counting from base is an index level;
+// if indexEvery is zero there are no index levels.
+func IntervalLevels(lo, hi, interval, base float64, indexEvery int) Levels {
+	if interval <= 0 || hi < lo {
+		return nil
+	}
+	k0 := int(math.Ceil((lo - base) / interval))
+	k1 := int(math.Floor((hi - base) / interval))
+	var levels Levels
+	for k := k0; k <= k1; k++ {
+		index := indexEvery > 0 && k%indexEvery == 0
+		levels = append(levels, Level{base + float64(k)*interval, index})
+	}
+	return levels
+}
+
+// ExplicitLevels returns the given values, sorted and without duplicates,
+// as intermediate levels.
+func ExplicitLevels(values ...float64) Levels {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	var levels Levels
+	for i, v := range sorted {
+		if IsNoData(v) || (i > 0 && v == sorted[i-1]) {
+			continue
+		}
+		levels = append(levels, Level{v, false})
+	}
+	return levels
+}
+
+// QuantileLevels returns n levels that split the valid elevations into
+// n+1 bands of equal area. Duplicate levels, which occur on flat terrain,
+// are dropped.
+func QuantileLevels(elevation []float64, n int) Levels {
+	var values []float64
+	for _, e := range elevation {
+		if !IsNoData(e) {
+			values = append(values, e)
+		}
+	}
+	if len(values) == 0 || n <= 0 {
+		return nil
+	}
+	sort.Float64s(values)
+	quantiles := make([]float64, n)
+	for i := range quantiles {
+		t := float64(i+1) / float64(n+1) * float64(len(values)-1)
+		j := int(t)
+		if j+1 < len(values) {
+			quantiles[i] = values[j] + (values[j+1]-values[j])*(t-float64(j))
+		} else {
+			quantiles[i] = values[j]
+		}
+	}
+	return ExplicitLevels(quantiles...)
+}
+
+// LogLevels returns n levels from lo to hi spaced logarithmically in
+// height above lo, so they are densest near lo. This suits terrain with
+// most of its detail in the lowlands.
+func LogLevels(lo, hi float64, n int) Levels {
+	if n <= 0 || hi < lo {
+		return nil
+	}
+	if n == 1 {
+		return Levels{{lo, false}}
+	}
+	span := math.Log1p(hi - lo)
+	levels := make(Levels, n)
+	for i := range levels {
+		t := float64(i) / float64(n-1)
+		levels[i] = Level{lo + math.Expm1(t*span), false}
+	}
+	levels[n-1].Value = hi
+	return levels
+}
+
+// NiceInterval returns a round contour interval (1, 2, 2.5 or 5 times a
+// power of ten) that gives about count levels between lo and hi, along
+// with how many intervals apart index levels should be so that they also
+// fall on round values.
+func NiceInterval(lo, hi float64, count int) (float64, int) {
+	if count <= 0 || hi <= lo {
+		return 0, 0
+	}
+	raw := (hi - lo) / float64(count)
+	scale := math.Pow(10, math.Floor(math.Log10(raw)))
+	steps := []struct {
+		Step  float64
+		Index int
+	}{{1, 5}, {2, 5}, {2.5, 4}, {5, 2}, {10, 5}}
+	best := steps[0]
+	for _, s := range steps {
+		if math.Abs(s.Step*scale-raw) < math.Abs(best.Step*scale-raw) {
+			best = s
+		}
+	}
+	return best.Step * scale, best.Index
+}
+
+// NiceLevels returns about count levels between lo and hi at a round
+// interval, with index levels at round multiples of it.
+func NiceLevels(lo, hi float64, count int) Levels {
+	interval, indexEvery := NiceInterval(lo, hi, count)
+	return IntervalLevels(lo, hi, interval, 0, indexEvery)
+}
@@ -0,0 +1,121 @@
+package terrarium
+
+import (
+	"container/list"
+	"image"
+	"sync"
+)
+
+type MemoryStats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	Count     int
+	Bytes     int64
+}
+
+// tileLRU is a bounded, concurrency-safe LRU of decoded tile images. A zero
+// maxCount or maxBytes means that limit is not enforced.
+type tileLRU struct {
+	mu       sync.Mutex
+	maxCount int
+	maxBytes int64
+	list     *list.List
+	items    map[TileID]*list.Element
+	stats    MemoryStats
+}
+
+type lruEntry struct {
+	id    TileID
+	im    image.Image
+	bytes int64
+}
+
+func newTileLRU(maxCount int, maxBytes int64) *tileLRU {
+	return &tileLRU{
+		maxCount: maxCount,
+		maxBytes: maxBytes,
+		list:     list.New(),
+		items:    make(map[TileID]*list.Element),
+	}
+}
+
+func (lru *tileLRU) Get(id TileID) (image.Image, bool) {
+	lru.mu.Lock()
+	defer lru.mu.Unlock()
+	if e, ok := lru.items[id]; ok {
+		lru.list.MoveToFront(e)
+		lru.stats.Hits++
+		return e.Value.(*lruEntry).im, true
+	}
+	lru.stats.Misses++
+	return nil, false
+}
+
+func (lru *tileLRU) Add(id TileID, im image.Image) {
+	lru.mu.Lock()
+	defer lru.mu.Unlock()
+	if e, ok := lru.items[id]; ok {
+		lru.remove(e)
+	}
+	entry := &lruEntry{id, im, imageBytes(im)}
+	lru.items[id] = lru.list.PushFront(entry)
+	lru.stats.Count++
+	lru.stats.Bytes += entry.bytes
+	for lru.list.Len() > 1 && lru.overLimit() {
+		lru.remove(lru.list.Back())
+		lru.stats.Evictions++
+	}
+}
+
+func (lru *tileLRU) Remove(id TileID) {
+	lru.mu.Lock()
+	defer lru.mu.Unlock()
+	if e, ok := lru.items[id]; ok {
+		lru.remove(e)
+	}
+}
+
+func (lru *tileLRU) Stats() MemoryStats {
+	lru.mu.Lock()
+	defer lru.mu.Unlock()
+	return lru.stats
+}
+
+func (lru *tileLRU) overLimit() bool {
+	if lru.maxCount > 0 && lru.stats.Count > lru.maxCount {
+		return true
+	}
+	if lru.maxBytes > 0 && lru.stats.Bytes > lru.maxBytes {
+		return true
+	}
+	return false
+}
+
+func (lru *tileLRU) remove(e *list.Element) {
+	entry := lru.list.Remove(e).(*lruEntry)
+	delete(lru.items, entry.id)
+	lru.stats.Count--
+	lru.stats.Bytes -= entry.bytes
+}
+
+func imageBytes(im image.Image) int64 {
+	switch im := im.(type) {
+	case *image.RGBA:
+		return int64(len(im.Pix))
+	case *image.NRGBA:
+		return int64(len(im.Pix))
+	case *image.RGBA64:
+		return int64(len(im.Pix))
+	case *image.NRGBA64:
+		return int64(len(im.Pix))
+	case *image.Gray:
+		return int64(len(im.Pix))
+	case *image.Gray16:
+		return int64(len(im.Pix))
+	case *image.Paletted:
+		return int64(len(im.Pix))
+	}
+	size := im.Bounds().Size()
+	return int64(size.X * size.Y * 4)
+}
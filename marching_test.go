@@ -0,0 +1,198 @@
+package terrarium
+
+import (
+	"math"
+	"testing"
+)
+
+var contourMethods = []ContourMethod{Triangles, MarchingSquares}
+
+func makeGrid(w, h int, f func(x, y float64) float64) []float64 {
+	grid := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			grid[y*w+x] = f(float64(x), float64(y))
+		}
+	}
+	return grid
+}
+
+func coneGrid() ([]float64, int, int) {
+	w, h := 41, 41
+	grid := makeGrid(w, h, func(x, y float64) float64 {
+		return 100 - 4*math.Hypot(x-20.3, y-19.6)
+	})
+	return grid, w, h
+}
+
+func signedArea(path Path) float64 {
+	var a float64
+	for i := 1; i < len(path); i++ {
+		a += path[i-1].X*path[i].Y - path[i].X*path[i-1].Y
+	}
+	return a / 2
+}
+
+func vertexCount(paths []Path) int {
+	var n int
+	for _, path := range paths {
+		n += len(path)
+	}
+	return n
+}
+
+func TestSliceConeClosedRings(t *testing.T) {
+	grid, w, h := coneGrid()
+	for _, method := range contourMethods {
+		for _, z := range []float64{30, 50, 70, 90} {
+			paths := SliceWith(method, grid, w, h, z)
+			if len(paths) != 1 {
+				t.Fatalf("method %d level %g: got %d paths, want 1", method, z, len(paths))
+			}
+			path := paths[0]
+			if path[0] != path[len(path)-1] {
+				t.Errorf("method %d level %g: ring is not closed", method, z)
+			}
+			// higher ground on the left with y down makes hills negative
+			r := (100 - z) / 4
+			want := -math.Pi * r * r
+			if got := signedArea(path); math.Abs(got-want) > 0.05*math.Abs(want) {
+				t.Errorf("method %d level %g: area %g, want about %g", method, z, got, want)
+			}
+		}
+	}
+}
+
+func TestSliceMethodsAgree(t *testing.T) {
+	surfaces := []struct {
+		Name  string
+		F     func(x, y float64) float64
+		Level float64
+	}{
+		{"ramp", func(x, y float64) float64 { return 0.7*x + 0.3*y }, 30.37},
+		{"saddle", func(x, y float64) float64 { return (x - 50.2) * (y - 50.1) / 10 }, 5.37},
+		{"waves", func(x, y float64) float64 { return 10 * math.Sin(x/7) * math.Cos(y/5) }, 0.37},
+	}
+	w, h := 101, 101
+	for _, s := range surfaces {
+		name, z := s.Name, s.Level
+		grid := makeGrid(w, h, s.F)
+		tri := SliceWith(Triangles, grid, w, h, z)
+		ms := SliceWith(MarchingSquares, grid, w, h, z)
+		if len(tri) != len(ms) {
+			t.Errorf("%s level %g: %d paths with triangles, %d with marching squares",
+				name, z, len(tri), len(ms))
+		}
+		var a0, a1, total float64
+		for _, path := range tri {
+			a0 += signedArea(path)
+			total += math.Abs(signedArea(path))
+		}
+		for _, path := range ms {
+			a1 += signedArea(path)
+		}
+		if math.Abs(a0-a1) > 0.02*total {
+			t.Errorf("%s level %g: signed area %g with triangles, %g with marching squares",
+				name, z, a0, a1)
+		}
+		n0, n1 := vertexCount(tri), vertexCount(ms)
+		if n1 >= n0*6/10 {
+			t.Errorf("%s level %g: %d vertices with marching squares, %d with triangles",
+				name, z, n1, n0)
+		}
+	}
+}
+
+func TestSliceCheckerboardSaddle(t *testing.T) {
+	// the corners and centre are high and the edge midpoints low, so every
+	// cell is a saddle whose bilinear saddle value is 0.5
+	grid := []float64{
+		1, 0, 1,
+		0, 1, 0,
+		1, 0, 1,
+	}
+	for _, method := range contourMethods {
+		// below the saddle the high corners connect through each cell and
+		// the four low points are cut off by open arcs
+		paths := SliceWith(method, grid, 3, 3, 0.4)
+		if len(paths) != 4 {
+			t.Errorf("method %d level 0.4: got %d paths, want 4", method, len(paths))
+		}
+		for _, path := range paths {
+			if path[0] == path[len(path)-1] {
+				t.Errorf("method %d level 0.4: unexpected closed ring", method)
+			}
+		}
+
+		// above the saddle the centre is isolated in a ring of its own
+		var rings []Path
+		for _, path := range SliceWith(method, grid, 3, 3, 0.6) {
+			if path[0] == path[len(path)-1] {
+				rings = append(rings, path)
+			}
+		}
+		if len(rings) != 1 {
+			t.Fatalf("method %d level 0.6: got %d rings, want 1", method, len(rings))
+		}
+		for _, p := range rings[0] {
+			if math.Abs(p.X-1) >= 1 || math.Abs(p.Y-1) >= 1 {
+				t.Errorf("method %d level 0.6: ring point %v is not around the centre", method, p)
+			}
+		}
+	}
+}
+
+func TestSliceQuantizedLevels(t *testing.T) {
+	// an integer cone sliced exactly at its sample values must still give
+	// closed rings with no repeated or collapsed points
+	w, h := 41, 41
+	grid := makeGrid(w, h, func(x, y float64) float64 {
+		return math.Max(0, math.Round(20-math.Hypot(x-20, y-20)))
+	})
+	for _, method := range contourMethods {
+		for z := 1.0; z < 20; z++ {
+			paths := SliceWith(method, grid, w, h, z)
+			if len(paths) != 1 {
+				t.Errorf("method %d level %g: got %d paths, want 1", method, z, len(paths))
+				continue
+			}
+			path := paths[0]
+			if path[0] != path[len(path)-1] {
+				t.Errorf("method %d level %g: ring is not closed", method, z)
+			}
+			if len(path) < 4 {
+				t.Errorf("method %d level %g: degenerate ring of %d points", method, z, len(path))
+			}
+			for i := 1; i < len(path); i++ {
+				if path[i] == path[i-1] {
+					t.Errorf("method %d level %g: repeated point %v", method, z, path[i])
+				}
+			}
+			if signedArea(path) >= 0 {
+				t.Errorf("method %d level %g: ring has area %g", method, z, signedArea(path))
+			}
+		}
+		// the peak equals the top level, so nothing is above it
+		if paths := SliceWith(method, grid, w, h, 20); len(paths) != 0 {
+			t.Errorf("method %d level 20: got %d paths, want 0", method, len(paths))
+		}
+	}
+}
+
+func TestSliceSinglePitCollapses(t *testing.T) {
+	// the ring around a lone sample equal to the level shrinks onto that
+	// sample and is dropped
+	grid := []float64{
+		1, 1, 1,
+		1, 0, 1,
+		1, 1, 1,
+	}
+	for _, method := range contourMethods {
+		if paths := SliceWith(method, grid, 3, 3, 0); len(paths) != 0 {
+			t.Errorf("method %d: got %d paths, want 0", method, len(paths))
+		}
+		if paths := SliceWith(method, grid, 3, 3, 0.5); len(paths) != 1 {
+			t.Errorf("method %d level 0.5: got %d paths, want 1", method, len(paths))
+		}
+	}
+}
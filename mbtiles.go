@@ -0,0 +1,203 @@
+package terrarium
+
+import (
+	"bytes"
+	"database/sql"
+	"image"
+	"io/ioutil"
+	"os"
+	"strconv"
+)
+
+const mbtilesSchema = `
+CREATE TABLE IF NOT EXISTS metadata (name TEXT, value TEXT);
+CREATE UNIQUE INDEX IF NOT EXISTS metadata_name ON metadata (name);
+CREATE TABLE IF NOT EXISTS tiles (
+	zoom_level INTEGER,
+	tile_column INTEGER,
+	tile_row INTEGER,
+	tile_data BLOB
+);
+CREATE UNIQUE INDEX IF NOT EXISTS tile_index ON tiles (zoom_level, tile_column, tile_row);
+`
+
+// MBTiles is a tile store backed by an MBTiles (SQLite) file. The package
+// does not import a SQLite driver; programs must import one that registers
+// itself as "sqlite3", such as github.com/mattn/go-sqlite3.
+//
+// MBTiles rows use the TMS scheme, so y is flipped relative to the XYZ tile
+// coordinates used everywhere else in this package.
+type MBTiles struct {
+	Encoding ElevationEncoding
+	db       *sql.DB
+}
+
+func NewMBTiles(db *sql.DB) *MBTiles {
+	return &MBTiles{db: db}
+}
+
+func OpenMBTiles(path string) (*MBTiles, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	return &MBTiles{db: db}, nil
+}
+
+// CreateMBTiles opens the MBTiles file at path, creating it and its tables if
+// needed.
+func CreateMBTiles(path, name string) (*MBTiles, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	m := &MBTiles{db: db}
+	if _, err := db.Exec(mbtilesSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	for key, value := range map[string]string{
+		"name":   name,
+		"format": "png",
+		"type":   "baselayer",
+	} {
+		if err := m.SetMetadata(key, value); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *MBTiles) TileEncoding() ElevationEncoding {
+	return encodingOrDefault(m.Encoding)
+}
+
+func (m *MBTiles) Close() error {
+	return m.db.Close()
+}
+
+func (m *MBTiles) SetMetadata(name, value string) error {
+	_, err := m.db.Exec(
+		"INSERT OR REPLACE INTO metadata (name, value) VALUES (?, ?)",
+		name, value)
+	return err
+}
+
+func (m *MBTiles) Metadata(name string) (string, error) {
+	var value string
+	err := m.db.QueryRow(
+		"SELECT value FROM metadata WHERE name = ?", name).Scan(&value)
+	return value, err
+}
+
+func (m *MBTiles) TileData(z, x, y int) ([]byte, error) {
+	var data []byte
+	err := m.db.QueryRow(
+		"SELECT tile_data FROM tiles WHERE zoom_level = ? AND tile_column = ? AND tile_row = ?",
+		z, x, flipY(z, y)).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, &TileError{TileID{z, x, y}, ErrTileNotFound}
+	}
+	return data, err
+}
+
+func (m *MBTiles) TileImage(z, x, y int) (image.Image, error) {
+	data, err := m.TileData(z, x, y)
+	if err != nil {
+		return nil, err
+	}
+	im, _, err := image.Decode(bytes.NewReader(data))
+	return im, err
+}
+
+func (m *MBTiles) PutTile(z, x, y int, data []byte) error {
+	return putTile(m.db, z, x, y, data)
+}
+
+// PackDirectory copies every tile in a {z}/{x}/{y}.png directory, such as a
+// Cache directory, into the MBTiles file and returns the number of tiles.
+func (m *MBTiles) PackDirectory(directory string) (int, error) {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	minZoom, maxZoom := -1, -1
+	err = walkTiles(directory, func(id TileID, path string, info os.FileInfo) error {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := putTile(tx, id.Z, id.X, id.Y, data); err != nil {
+			return err
+		}
+		if minZoom < 0 || id.Z < minZoom {
+			minZoom = id.Z
+		}
+		if id.Z > maxZoom {
+			maxZoom = id.Z
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		if err := m.SetMetadata("minzoom", strconv.Itoa(minZoom)); err != nil {
+			return count, err
+		}
+		if err := m.SetMetadata("maxzoom", strconv.Itoa(maxZoom)); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// UnpackDirectory writes every tile in the MBTiles file into a
+// {z}/{x}/{y}.png directory and returns the number of tiles.
+func (m *MBTiles) UnpackDirectory(directory string) (int, error) {
+	rows, err := m.db.Query("SELECT zoom_level, tile_column, tile_row, tile_data FROM tiles")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	count := 0
+	for rows.Next() {
+		var z, x, row int
+		var data []byte
+		if err := rows.Scan(&z, &x, &row, &data); err != nil {
+			return count, err
+		}
+		path := tilePath(directory, z, x, flipY(z, row))
+		if err := writeFileAtomic(path, data); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, rows.Err()
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func putTile(db execer, z, x, y int, data []byte) error {
+	_, err := db.Exec(
+		"INSERT OR REPLACE INTO tiles (zoom_level, tile_column, tile_row, tile_data) VALUES (?, ?, ?, ?)",
+		z, x, flipY(z, y), data)
+	return err
+}
+
+// flipY converts between XYZ and TMS tile rows. It is its own inverse.
+func flipY(z, y int) int {
+	return (1 << uint(z)) - 1 - y
+}
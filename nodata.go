@@ -0,0 +1,56 @@
+package terrarium
+
+import (
+	"image"
+	"math"
+)
+
+// NoData marks elevation samples without data. It is a NaN, so use IsNoData
+// rather than == to test for it.
+var NoData = math.NaN()
+
+func IsNoData(e float64) bool {
+	return math.IsNaN(e)
+}
+
+// elevationRange returns the min and max of the valid elevations, or NoData
+// if there are none.
+func elevationRange(elevation []float64) (float64, float64) {
+	lo, hi := NoData, NoData
+	found := false
+	for _, e := range elevation {
+		if IsNoData(e) {
+			continue
+		}
+		if !found {
+			lo, hi = e, e
+			found = true
+		}
+		if e < lo {
+			lo = e
+		}
+		if e > hi {
+			hi = e
+		}
+	}
+	return lo, hi
+}
+
+// validMask returns an alpha mask that is opaque where there is data, or nil
+// if there is data everywhere.
+func validMask(elevation []float64, w, h int) *image.Alpha {
+	var mask *image.Alpha
+	for i, e := range elevation {
+		if !IsNoData(e) {
+			continue
+		}
+		if mask == nil {
+			mask = image.NewAlpha(image.Rect(0, 0, w, h))
+			for j := range mask.Pix {
+				mask.Pix[j] = 255
+			}
+		}
+		mask.Pix[i] = 0
+	}
+	return mask
+}
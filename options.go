@@ -0,0 +1,99 @@
+package terrarium
+
+import (
+	"net/http"
+	"time"
+)
+
+type CacheOption func(cache *Cache)
+
+// WithHTTPClient sets the client used for downloads, e.g. to configure
+// timeouts, proxies or a stub transport. The default is http.DefaultClient.
+func WithHTTPClient(client *http.Client) CacheOption {
+	return func(cache *Cache) {
+		cache.client = client
+	}
+}
+
+// WithHeader adds a header that is sent with every tile request.
+func WithHeader(key, value string) CacheOption {
+	return func(cache *Cache) {
+		cache.header.Add(key, value)
+	}
+}
+
+func WithUserAgent(userAgent string) CacheOption {
+	return func(cache *Cache) {
+		cache.header.Set("User-Agent", userAgent)
+	}
+}
+
+// WithRateLimit limits downloads to the given number of requests per second,
+// in addition to the MaxDownloads concurrency limit.
+func WithRateLimit(requestsPerSecond float64) CacheOption {
+	return func(cache *Cache) {
+		cache.limiter = newRateLimiter(requestsPerSecond)
+	}
+}
+
+func WithRetries(retries int, delay time.Duration) CacheOption {
+	return func(cache *Cache) {
+		cache.Retries = retries
+		cache.RetryDelay = delay
+	}
+}
+
+func WithProgress(onProgress ProgressFunc) CacheOption {
+	return func(cache *Cache) {
+		cache.OnProgress = onProgress
+	}
+}
+
+// WithRevalidation makes EnsureTile re-check cached tiles that are older than
+// maxAge using a conditional request (If-None-Match / If-Modified-Since), so
+// unchanged tiles cost a 304 response instead of a full download.
+func WithRevalidation(maxAge time.Duration) CacheOption {
+	return func(cache *Cache) {
+		cache.revalidateAfter = maxAge
+	}
+}
+
+// WithMemoryCache keeps up to maxTiles decoded tile images, using at most
+// maxBytes of pixel data, in an in-memory LRU. Zero disables either limit.
+func WithMemoryCache(maxTiles int, maxBytes int64) CacheOption {
+	return func(cache *Cache) {
+		cache.memory = newTileLRU(maxTiles, maxBytes)
+	}
+}
+
+// WithOnDemand makes GetTile, GetStitchedTile and TileImage download missing
+// tiles (including the neighbours needed for stitching) instead of failing.
+func WithOnDemand() CacheOption {
+	return func(cache *Cache) {
+		cache.onDemand = true
+	}
+}
+
+// WithOffline prevents the cache from ever downloading tiles. Requests for
+// tiles that are not cached fail with ErrOffline.
+func WithOffline() CacheOption {
+	return func(cache *Cache) {
+		cache.offline = true
+	}
+}
+
+// WithEncoding sets how tile images are decoded into elevations. The default
+// is TerrariumEncoding.
+func WithEncoding(encoding ElevationEncoding) CacheOption {
+	return func(cache *Cache) {
+		cache.Encoding = encoding
+	}
+}
+
+// WithTileSize sets the expected size of the source's tiles, in pixels, for
+// Verify and Coverage. The default is TileSize.
+func WithTileSize(size int) CacheOption {
+	return func(cache *Cache) {
+		cache.tileSize = size
+	}
+}
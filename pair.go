@@ -1,7 +1,49 @@
 package terrarium
 
+// vertexID identifies a grid value, or the centre of a cell when Center is
+// set, by its pixel coordinates.
+type vertexID struct {
+	X, Y   int
+	Center bool
+}
+
+func (v vertexID) less(u vertexID) bool {
+	if v.Y != u.Y {
+		return v.Y < u.Y
+	}
+	if v.X != u.X {
+		return v.X < u.X
+	}
+	return !v.Center && u.Center
+}
+
+// edgeID identifies the triangulation edge that a contour vertex lies on.
+type edgeID struct {
+	A, B vertexID
+}
+
+func makeEdgeID(a, b vertexID) edgeID {
+	if b.less(a) {
+		a, b = b, a
+	}
+	return edgeID{a, b}
+}
+
+func (e edgeID) offset(dx, dy, wrap int) edgeID {
+	move := func(v vertexID) vertexID {
+		v.X += dx
+		v.Y += dy
+		if wrap > 0 {
+			v.X = ((v.X % wrap) + wrap) % wrap
+		}
+		return v
+	}
+	return makeEdgeID(move(e.A), move(e.B))
+}
+
 type pair struct {
-	A, B Point
+	A, B   Point
+	KA, KB edgeID
 }
 
 func pairsToPaths(pairs []pair) []Path {
@@ -12,41 +54,36 @@ func pairsToPaths(pairs []pair) []Path {
 	return paths
 }
 
+func pairsToFragments(pairs []pair, level float64) []Fragment {
+	fragments := make([]Fragment, len(pairs))
+	for i, p := range pairs {
+		fragments[i] = Fragment{level, Path{p.A, p.B}, p.KA, p.KB}
+	}
+	return fragments
+}
+
 func joinPairs(pairs []pair) []Path {
 	// return pairsToPaths(pairs)
-	lookup := make(map[Point][]Point, len(pairs))
-	for _, pair := range pairs {
-		lookup[pair.A] = append(lookup[pair.A], pair.B)
-	}
-	var result []Path
-	for len(lookup) > 0 {
-		var p Point
-		for p = range lookup {
-			break
-		}
-		var path Path
-		for {
-			path = append(path, p)
-			if qs, ok := lookup[p]; ok {
-				q, a := qs[0], qs[1:]
-				if len(a) == 0 {
-					delete(lookup, p)
-				} else {
-					lookup[p] = a
-				}
-				p = q
-			} else {
-				break
-			}
-		}
-		// if len(path) < 10 {
-		// 	a := path[0]
-		// 	b := path[len(path)-1]
-		// 	if a.Distance(b) < 1e-6 {
-		// 		continue
-		// 	}
-		// }
-		result = append(result, path)
+	fragments := JoinFragments(pairsToFragments(pairs, 0))
+	result := make([]Path, len(fragments))
+	for i, f := range fragments {
+		result[i] = f.Path
 	}
 	return result
 }
+
+func pairsToLevels(levels [][]pair) [][]Path {
+	result := make([][]Path, len(levels))
+	for i, pairs := range levels {
+		result[i] = joinPairs(pairs)
+	}
+	return result
+}
+
+func levelsToFragments(pairs [][]pair, levels []float64) []Fragment {
+	var fragments []Fragment
+	for i, z := range levels {
+		fragments = append(fragments, pairsToFragments(pairs[i], z)...)
+	}
+	return JoinFragments(fragments)
+}
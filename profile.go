@@ -0,0 +1,172 @@
+package terrarium
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+
+	"github.com/fogleman/gg"
+)
+
+type ProfilePoint struct {
+	LatLng    Point
+	Distance  float64
+	Elevation float64
+}
+
+// Profile is the elevation along a path. Distances and elevations are in
+// meters; MaxGrade is rise over run between consecutive samples, e.g. 0.1
+// for a 10% grade.
+type Profile struct {
+	Points       []ProfilePoint
+	Distance     float64
+	Ascent       float64
+	Descent      float64
+	MaxGrade     float64
+	MinElevation float64
+	MaxElevation float64
+}
+
+// ElevationProfile samples the elevation every spacing meters along a
+// LatLng path. Samples with NoData are kept but ignored by the statistics.
+func ElevationProfile(sampler *Sampler, path Path, spacing float64) (*Profile, error) {
+	points, distances, err := path.Resample(spacing)
+	if err != nil {
+		return nil, err
+	}
+	elevations, err := sampler.ElevationsAt(points)
+	if err != nil {
+		return nil, err
+	}
+	profile := &Profile{}
+	for i, p := range points {
+		profile.Points = append(profile.Points, ProfilePoint{p, distances[i], elevations[i]})
+	}
+	profile.MinElevation, profile.MaxElevation = elevationRange(elevations)
+	if len(distances) > 0 {
+		profile.Distance = distances[len(distances)-1]
+	}
+	var prev *ProfilePoint
+	for i := range profile.Points {
+		p := &profile.Points[i]
+		if IsNoData(p.Elevation) {
+			continue
+		}
+		if prev != nil {
+			de := p.Elevation - prev.Elevation
+			if de > 0 {
+				profile.Ascent += de
+			} else {
+				profile.Descent -= de
+			}
+			if dd := p.Distance - prev.Distance; dd > 0 {
+				profile.MaxGrade = math.Max(profile.MaxGrade, math.Abs(de)/dd)
+			}
+		}
+		prev = p
+	}
+	return profile, nil
+}
+
+const profileMargin = 50
+
+// chart returns a function mapping distance and elevation to chart
+// coordinates, with y increasing downward.
+func (profile *Profile) chart(w, h int) func(d, e float64) (float64, float64) {
+	lo, hi := profile.MinElevation, profile.MaxElevation
+	if IsNoData(lo) {
+		lo, hi = 0, 1
+	}
+	if hi-lo < 1 {
+		hi = lo + 1
+	}
+	distance := math.Max(profile.Distance, 1)
+	pw := float64(w - profileMargin*2)
+	ph := float64(h - profileMargin*2)
+	return func(d, e float64) (float64, float64) {
+		x := profileMargin + d/distance*pw
+		y := profileMargin + (1-(e-lo)/(hi-lo))*ph
+		return x, y
+	}
+}
+
+func (profile *Profile) segments() [][]ProfilePoint {
+	var result [][]ProfilePoint
+	var segment []ProfilePoint
+	for _, p := range profile.Points {
+		if IsNoData(p.Elevation) {
+			if len(segment) > 0 {
+				result = append(result, segment)
+			}
+			segment = nil
+			continue
+		}
+		segment = append(segment, p)
+	}
+	if len(segment) > 0 {
+		result = append(result, segment)
+	}
+	return result
+}
+
+func (profile *Profile) labels() (string, string, string) {
+	return fmt.Sprintf("%.0f m", profile.MaxElevation),
+		fmt.Sprintf("%.0f m", profile.MinElevation),
+		fmt.Sprintf("%.2f km, +%.0f m / -%.0f m, max grade %.1f%%",
+			profile.Distance/1000, profile.Ascent, profile.Descent, profile.MaxGrade*100)
+}
+
+// Render draws the profile as a line chart.
+func (profile *Profile) Render(w, h int) image.Image {
+	transform := profile.chart(w, h)
+	dc := gg.NewContext(w, h)
+	dc.SetRGB(1, 1, 1)
+	dc.Clear()
+	dc.SetRGB(0.6, 0.6, 0.6)
+	dc.SetLineWidth(1)
+	x0, y0 := transform(0, profile.MinElevation)
+	x1, y1 := transform(profile.Distance, profile.MaxElevation)
+	dc.DrawRectangle(x0, y1, x1-x0, y0-y1)
+	dc.Stroke()
+	dc.SetRGB(0, 0, 0)
+	dc.SetLineWidth(2)
+	for _, segment := range profile.segments() {
+		dc.NewSubPath()
+		for _, p := range segment {
+			dc.LineTo(transform(p.Distance, p.Elevation))
+		}
+	}
+	dc.Stroke()
+	top, bottom, summary := profile.labels()
+	dc.DrawStringAnchored(top, x0-4, y1, 1, 0.5)
+	dc.DrawStringAnchored(bottom, x0-4, y0, 1, 0.5)
+	dc.DrawStringAnchored(summary, float64(w)/2, y0+profileMargin/2, 0.5, 0.5)
+	return dc.Image()
+}
+
+// SVG renders the profile as an SVG document.
+func (profile *Profile) SVG(w, h int) string {
+	transform := profile.chart(w, h)
+	x0, y0 := transform(0, profile.MinElevation)
+	x1, y1 := transform(profile.Distance, profile.MaxElevation)
+	top, bottom, summary := profile.labels()
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n", w, h, w, h)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="white"/>`+"\n", w, h)
+	fmt.Fprintf(&b, `<rect x="%g" y="%g" width="%g" height="%g" fill="none" stroke="#999"/>`+"\n", x0, y1, x1-x0, y0-y1)
+	for _, segment := range profile.segments() {
+		var points []string
+		for _, p := range segment {
+			x, y := transform(p.Distance, p.Elevation)
+			points = append(points, fmt.Sprintf("%.2f,%.2f", x, y))
+		}
+		fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="black" stroke-width="2"/>`+"\n", strings.Join(points, " "))
+	}
+	fmt.Fprintf(&b, `<g font-family="sans-serif" font-size="12">`+"\n")
+	fmt.Fprintf(&b, `<text x="%g" y="%g" text-anchor="end" dominant-baseline="middle">%s</text>`+"\n", x0-4, y1, top)
+	fmt.Fprintf(&b, `<text x="%g" y="%g" text-anchor="end" dominant-baseline="middle">%s</text>`+"\n", x0-4, y0, bottom)
+	fmt.Fprintf(&b, `<text x="%g" y="%g" text-anchor="middle" dominant-baseline="middle">%s</text>`+"\n", float64(w)/2, y0+profileMargin/2, summary)
+	fmt.Fprintf(&b, "</g>\n</svg>\n")
+	return b.String()
+}
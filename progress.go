@@ -0,0 +1,54 @@
+package terrarium
+
+type TileStatus int
+
+const (
+	TileQueued TileStatus = iota
+	TileDownloaded
+	TileCached
+	TileFailed
+	TileNotModified
+)
+
+func (status TileStatus) String() string {
+	switch status {
+	case TileQueued:
+		return "queued"
+	case TileDownloaded:
+		return "downloaded"
+	case TileCached:
+		return "cached"
+	case TileFailed:
+		return "failed"
+	case TileNotModified:
+		return "not modified"
+	}
+	return "unknown"
+}
+
+// Progress holds running totals for the tiles requested from a Cache.
+type Progress struct {
+	Queued      int
+	Downloaded  int
+	Cached      int
+	Failed      int
+	NotModified int
+	Bytes       int64
+}
+
+// Pending returns the number of queued tiles that have not finished yet.
+func (p Progress) Pending() int {
+	return p.Queued - p.Downloaded - p.Failed - p.NotModified
+}
+
+// ProgressEvent describes a single tile changing status. Progress holds the
+// totals after the change has been applied.
+type ProgressEvent struct {
+	TileID
+	Status   TileStatus
+	Bytes    int64
+	Err      error
+	Progress Progress
+}
+
+type ProgressFunc func(event ProgressEvent)
@@ -0,0 +1,184 @@
+package terrarium
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"runtime"
+	"sync"
+)
+
+// ElevationFunc returns the elevation at a lat/lng and whether there is any
+// data there.
+type ElevationFunc func(lat, lng float64) (float64, bool)
+
+type PyramidOptions struct {
+	MinZoom, MaxZoom int
+	// Bounds limits the tiles that are generated.
+	Bounds Bounds
+	// TileSize is the size of the generated tiles in pixels. The default is
+	// TileSize.
+	TileSize int
+	// Encoding is used to write tiles and read them back when building the
+	// overviews. The default is TerrariumEncoding.
+	Encoding ElevationEncoding
+	// Workers is the number of tiles processed in parallel. The default is
+	// runtime.NumCPU().
+	Workers int
+	// OnTile, if set, is called after each tile is written. Calls are
+	// serialized.
+	OnTile func(z, x, y int)
+}
+
+// BuildPyramid writes a {z}/{x}/{y}.png Web Mercator tile pyramid into
+// directory. Pixel (px, py) of a tile sits at (px, py) / TileSize within the
+// tile, the same convention used when tiles are read. Tiles at MaxZoom are
+// sampled from elevation at each pixel; each lower zoom level takes every
+// other pixel of the tiles in the level below, which land on the same
+// points. Pixels without data are written as NoData and
+// tiles with no data at all are not written.
+func BuildPyramid(directory string, elevation ElevationFunc, options PyramidOptions) error {
+	encoding := encodingOrDefault(options.Encoding)
+	source := &DirectorySource{directory, encoding}
+	size := options.TileSize
+	if size <= 0 {
+		size = TileSize
+	}
+	if onTile := options.OnTile; onTile != nil {
+		var mu sync.Mutex
+		options.OnTile = func(z, x, y int) {
+			mu.Lock()
+			defer mu.Unlock()
+			onTile(z, x, y)
+		}
+	}
+	z := options.MaxZoom
+	tiles := TileRangeForBounds(z, options.Bounds).Tiles()
+	err := forEachTile(tiles, options.Workers, func(id TileID) error {
+		grid, ok := sampleTile(id, size, elevation)
+		if !ok {
+			return nil
+		}
+		return writePyramidTile(directory, id, size, grid, encoding, options.OnTile)
+	})
+	if err != nil {
+		return err
+	}
+	for z = options.MaxZoom - 1; z >= options.MinZoom; z-- {
+		tiles := TileRangeForBounds(z, options.Bounds).Tiles()
+		err := forEachTile(tiles, options.Workers, func(id TileID) error {
+			grid, ok, err := downsampleTile(source, id, size)
+			if err != nil || !ok {
+				return err
+			}
+			return writePyramidTile(directory, id, size, grid, encoding, options.OnTile)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sampleTile(id TileID, size int, elevation ElevationFunc) ([]float64, bool) {
+	grid := make([]float64, size*size)
+	found := false
+	i := 0
+	for py := 0; py < size; py++ {
+		for px := 0; px < size; px++ {
+			p := Point{
+				float64(id.X) + float64(px)/float64(size),
+				float64(id.Y) + float64(py)/float64(size),
+			}
+			q := TileLatLngFloat(id.Z, p)
+			if e, ok := elevation(q.Y, q.X); ok {
+				grid[i] = e
+				found = true
+			} else {
+				grid[i] = NoData
+			}
+			i++
+		}
+	}
+	return grid, found
+}
+
+func downsampleTile(source TileSource, id TileID, size int) ([]float64, bool, error) {
+	half := size / 2
+	grid := make([]float64, size*size)
+	for i := range grid {
+		grid[i] = NoData
+	}
+	found := false
+	for j := 0; j < 4; j++ {
+		dx, dy := j%2, j/2
+		child, err := GetTile(source, id.Z+1, id.X*2+dx, id.Y*2+dy)
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		found = true
+		e := child.Elevation
+		w := child.W
+		for y := 0; y < half; y++ {
+			for x := 0; x < half; x++ {
+				grid[(dy*half+y)*size+dx*half+x] = e[(y*2)*w+x*2]
+			}
+		}
+	}
+	return grid, found, nil
+}
+
+func writePyramidTile(directory string, id TileID, size int, grid []float64, encoder ElevationEncoder, onTile func(z, x, y int)) error {
+	im := encoder.EncodeElevation(grid, size, size)
+	if err := WriteTileImage(tilePath(directory, id.Z, id.X, id.Y), im); err != nil {
+		return err
+	}
+	if onTile != nil {
+		onTile(id.Z, id.X, id.Y)
+	}
+	return nil
+}
+
+// WriteTileImage atomically writes a PNG tile, creating directories as needed.
+func WriteTileImage(path string, im image.Image) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, im); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, buf.Bytes())
+}
+
+func forEachTile(tiles []TileID, workers int, fn func(id TileID) error) error {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	ch := make(chan TileID)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs TileErrors
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range ch {
+				if err := fn(id); err != nil {
+					mu.Lock()
+					errs = append(errs, &TileError{id, err})
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, id := range tiles {
+		ch <- id
+	}
+	close(ch)
+	wg.Wait()
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package terrarium
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPyramidPixelsMatchSamples(t *testing.T) {
+	elevation := func(lat, lng float64) (float64, bool) {
+		return lng*10 + lat, true
+	}
+	directory := t.TempDir()
+	size := 16
+	options := PyramidOptions{
+		MinZoom:  0,
+		MaxZoom:  2,
+		Bounds:   Bounds{LatLng(-80, -179), LatLng(80, 179)},
+		TileSize: size,
+	}
+	if err := BuildPyramid(directory, elevation, options); err != nil {
+		t.Fatal(err)
+	}
+	source := &DirectorySource{directory, nil}
+	for z := 0; z <= 2; z++ {
+		n := 1 << uint(z)
+		for tx := 0; tx < n; tx++ {
+			for ty := 0; ty < n; ty++ {
+				tile, err := GetTile(source, z, tx, ty)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for py := 0; py < size; py++ {
+					for px := 0; px < size; px++ {
+						p := tile.PixelToLatLng(Point{float64(px), float64(py)})
+						want, _ := elevation(p.Y, p.X)
+						got := tile.Elevation[py*tile.W+px]
+						if math.Abs(got-want) > 0.01 {
+							t.Fatalf("z%d/%d/%d (%d, %d): got %g, want %g",
+								z, tx, ty, px, py, got, want)
+						}
+					}
+				}
+			}
+		}
+	}
+}
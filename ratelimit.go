@@ -0,0 +1,47 @@
+package terrarium
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newRateLimiter(perSecond float64) *rateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	interval := time.Duration(float64(time.Second) / perSecond)
+	return &rateLimiter{interval: interval}
+}
+
+func (limiter *rateLimiter) Wait(ctx context.Context) error {
+	if limiter == nil {
+		return nil
+	}
+	limiter.mu.Lock()
+	now := time.Now()
+	t := limiter.next
+	if t.Before(now) {
+		t = now
+	}
+	limiter.next = t.Add(limiter.interval)
+	limiter.mu.Unlock()
+	d := t.Sub(now)
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,184 @@
+package terrarium
+
+import (
+	"math"
+	"sync"
+)
+
+type Interpolation int
+
+const (
+	Nearest Interpolation = iota
+	Bilinear
+	Bicubic
+)
+
+// maxSamplerTiles bounds the number of decoded tiles a Sampler keeps.
+const maxSamplerTiles = 64
+
+// Sampler looks up elevations at arbitrary lat/lngs from the tiles of a
+// source at a single zoom level. Samples near tile edges use the
+// neighbouring tiles. It is safe for concurrent use.
+type Sampler struct {
+	Source        TileSource
+	Zoom          int
+	Interpolation Interpolation
+
+	mu    sync.Mutex
+	tiles map[TileID]*Tile
+	size  int
+}
+
+func NewSampler(source TileSource, zoom int, interpolation Interpolation) *Sampler {
+	return &Sampler{
+		Source:        source,
+		Zoom:          zoom,
+		Interpolation: interpolation,
+		tiles:         make(map[TileID]*Tile),
+	}
+}
+
+func (cache *Cache) Sampler(zoom int, interpolation Interpolation) *Sampler {
+	return NewSampler(cache, zoom, interpolation)
+}
+
+// ElevationAt returns the elevation at a lat/lng, or NoData if there is no
+// data there. Where an interpolation would use NoData pixels it falls back
+// to the nearest pixel.
+func (s *Sampler) ElevationAt(lat, lng float64) (float64, error) {
+	f := TileXYFloat(s.Zoom, LatLng(lat, lng))
+	// wrap and clamp the first lookup like pixel does, e.g. for lng 180
+	n := 1 << uint(s.Zoom)
+	tx := ((int(math.Floor(f.X)) % n) + n) % n
+	ty := clampInt(int(math.Floor(f.Y)), 0, n-1)
+	t, err := s.tile(tx, ty)
+	if err != nil {
+		return 0, err
+	}
+	size := float64(t.Size)
+	x := f.X * size
+	y := f.Y * size
+	var e float64
+	switch s.Interpolation {
+	case Bilinear:
+		e, err = s.bilinear(x, y)
+	case Bicubic:
+		e, err = s.bicubic(x, y)
+	default:
+		e = NoData
+	}
+	if err != nil {
+		return 0, err
+	}
+	// missing neighbour tiles count as NoData, so the nearest pixel is used
+	// instead, falling back to the point's own tile if that one is missing
+	if IsNoData(e) {
+		e, err = s.pixel(int(math.Round(x)), int(math.Round(y)))
+		if isNotFound(err) {
+			return s.pixel(int(math.Floor(x)), int(math.Floor(y)))
+		}
+		return e, err
+	}
+	return e, nil
+}
+
+// ElevationsAt returns the elevations at many points, given as LatLng.
+func (s *Sampler) ElevationsAt(points []Point) ([]float64, error) {
+	result := make([]float64, len(points))
+	for i, p := range points {
+		e, err := s.ElevationAt(p.Y, p.X)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = e
+	}
+	return result, nil
+}
+
+func (s *Sampler) bilinear(x, y float64) (float64, error) {
+	x0 := math.Floor(x)
+	y0 := math.Floor(y)
+	var v [4]float64
+	for i := range v {
+		var err error
+		v[i], err = s.pixel(int(x0)+i%2, int(y0)+i/2)
+		if isNotFound(err) {
+			v[i] = NoData
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	tx := x - x0
+	ty := y - y0
+	a := v[0] + (v[1]-v[0])*tx
+	b := v[2] + (v[3]-v[2])*tx
+	return a + (b-a)*ty, nil
+}
+
+func (s *Sampler) bicubic(x, y float64) (float64, error) {
+	x0 := math.Floor(x)
+	y0 := math.Floor(y)
+	var rows [4]float64
+	for j := 0; j < 4; j++ {
+		var v [4]float64
+		for i := 0; i < 4; i++ {
+			var err error
+			v[i], err = s.pixel(int(x0)+i-1, int(y0)+j-1)
+			if isNotFound(err) {
+				v[i] = NoData
+			} else if err != nil {
+				return 0, err
+			}
+		}
+		rows[j] = cubic(v, x-x0)
+	}
+	return cubic(rows, y-y0), nil
+}
+
+// cubic is Catmull-Rom interpolation between v[1] and v[2].
+func cubic(v [4]float64, t float64) float64 {
+	a := -0.5*v[0] + 1.5*v[1] - 1.5*v[2] + 0.5*v[3]
+	b := v[0] - 2.5*v[1] + 2*v[2] - 0.5*v[3]
+	c := -0.5*v[0] + 0.5*v[2]
+	d := v[1]
+	return ((a*t+b)*t+c)*t + d
+}
+
+// pixel returns the elevation at global pixel coordinates, wrapping around
+// the antimeridian and clamping at the poles. A tile must have been loaded
+// first so that the tile size is known.
+func (s *Sampler) pixel(x, y int) (float64, error) {
+	n := 1 << uint(s.Zoom)
+	s.mu.Lock()
+	size := s.size
+	s.mu.Unlock()
+	x = ((x % (n * size)) + n*size) % (n * size)
+	y = clampInt(y, 0, n*size-1)
+	t, err := s.tile(x/size, y/size)
+	if err != nil {
+		return 0, err
+	}
+	return t.Elevation[(y%size)*t.W+x%size], nil
+}
+
+func (s *Sampler) tile(x, y int) (*Tile, error) {
+	id := TileID{s.Zoom, x, y}
+	s.mu.Lock()
+	t, ok := s.tiles[id]
+	s.mu.Unlock()
+	if ok {
+		return t, nil
+	}
+	t, err := GetTile(s.Source, id.Z, id.X, id.Y)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	if len(s.tiles) >= maxSamplerTiles {
+		s.tiles = make(map[TileID]*Tile)
+	}
+	s.tiles[id] = t
+	s.size = t.Size
+	s.mu.Unlock()
+	return t, nil
+}
@@ -0,0 +1,76 @@
+package terrarium
+
+import (
+	"math"
+	"testing"
+)
+
+// newRampSource returns a source whose tiles at zoom z have an elevation
+// equal to the global pixel x coordinate.
+func newRampSource(z, size int) *MemorySource {
+	source := NewMemorySource()
+	n := 1 << uint(z)
+	for ty := 0; ty < n; ty++ {
+		for tx := 0; tx < n; tx++ {
+			e := make([]float64, size*size)
+			for y := 0; y < size; y++ {
+				for x := 0; x < size; x++ {
+					e[y*size+x] = float64(tx*size + x)
+				}
+			}
+			source.SetTile(z, tx, ty, EncodeTerrarium(e, size, size))
+		}
+	}
+	return source
+}
+
+func TestSamplerInterpolation(t *testing.T) {
+	sampler := NewSampler(newRampSource(1, 16), 1, Bilinear)
+	p := PixelToLatLng(1, 16, Point{10.25, 7})
+	e, err := sampler.ElevationAt(p.Y, p.X)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(e-10.25) > 1e-6 {
+		t.Errorf("got %g, want 10.25", e)
+	}
+}
+
+func TestSamplerWrapsAndClamps(t *testing.T) {
+	for _, interpolation := range []Interpolation{Nearest, Bilinear, Bicubic} {
+		sampler := NewSampler(newRampSource(1, 16), 1, interpolation)
+		for _, p := range []Point{LatLng(10, 180), LatLng(10, -180), LatLng(89.9, 0), LatLng(-89.9, 0)} {
+			if _, err := sampler.ElevationAt(p.Y, p.X); err != nil {
+				t.Errorf("interpolation %d at %v: %v", interpolation, p, err)
+			}
+		}
+		e, err := sampler.ElevationAt(10, 180)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e != 0 {
+			t.Errorf("interpolation %d: got %g at lng 180, want 0 as at lng -180", interpolation, e)
+		}
+	}
+}
+
+func TestSamplerMissingNeighbour(t *testing.T) {
+	// only tile 0/0 is present, so the stencil near its edges is incomplete
+	source := newRampSource(1, 16)
+	ramp := NewMemorySource()
+	im, _ := source.TileImage(1, 0, 0)
+	ramp.SetTile(1, 0, 0, im)
+	for _, interpolation := range []Interpolation{Bilinear, Bicubic} {
+		sampler := NewSampler(ramp, 1, interpolation)
+		for _, px := range []float64{15.25, 15.75} {
+			p := PixelToLatLng(1, 16, Point{px, 7})
+			e, err := sampler.ElevationAt(p.Y, p.X)
+			if err != nil {
+				t.Fatalf("interpolation %d at pixel %g: %v", interpolation, px, err)
+			}
+			if e != 15 {
+				t.Errorf("interpolation %d at pixel %g: got %g, want 15", interpolation, px, e)
+			}
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package terrarium
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+)
+
+var ErrTileNotFound = errors.New("tile not found")
+
+// TileSource provides decoded tile images by z/x/y tile coordinate.
+type TileSource interface {
+	TileImage(z, x, y int) (image.Image, error)
+}
+
+func GetTile(source TileSource, z, x, y int) (*Tile, error) {
+	im, err := source.TileImage(z, x, y)
+	if err != nil {
+		return nil, err
+	}
+	size := im.Bounds().Size().X
+	return newTile(z, x, y, size, im, sourceEncoding(source)), nil
+}
+
+func GetStitchedTile(source TileSource, z, x, y int) (*Tile, error) {
+	im, err := stitchTile(source, z, x, y)
+	if err != nil {
+		return nil, err
+	}
+	size := im.Bounds().Size().X - 1
+	return newTile(z, x, y, size, im, sourceEncoding(source)), nil
+}
+
+func GetTileAt(source TileSource, z int, p Point) (*Tile, error) {
+	t := TileXY(z, p)
+	return GetTile(source, z, t.X, t.Y)
+}
+
+func tilePath(directory string, z, x, y int) string {
+	path := fmt.Sprintf("%d/%d/%d.png", z, x, y)
+	path = filepath.Join(directory, path)
+	return path
+}
+
+// walkTiles calls fn for every {z}/{x}/{y}.png file below directory.
+func walkTiles(directory string, fn func(id TileID, path string, info os.FileInfo) error) error {
+	return filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(directory, path)
+		if err != nil {
+			return nil
+		}
+		var id TileID
+		if !parseTilePath(rel, &id) {
+			return nil
+		}
+		return fn(id, path, info)
+	})
+}
+
+func parseTilePath(rel string, id *TileID) bool {
+	rel = filepath.ToSlash(rel)
+	var ext string
+	n, _ := fmt.Sscanf(rel, "%d/%d/%d%s", &id.Z, &id.X, &id.Y, &ext)
+	return n == 4 && ext == ".png"
+}
+
+func loadTileImage(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return png.Decode(file)
+}
+
+// DirectorySource reads tiles from a local {z}/{x}/{y}.png directory tree.
+type DirectorySource struct {
+	Directory string
+	Encoding  ElevationEncoding
+}
+
+func NewDirectorySource(directory string) *DirectorySource {
+	return &DirectorySource{Directory: directory}
+}
+
+func (source *DirectorySource) TileEncoding() ElevationEncoding {
+	return encodingOrDefault(source.Encoding)
+}
+
+func (source *DirectorySource) TileImage(z, x, y int) (image.Image, error) {
+	im, err := loadTileImage(tilePath(source.Directory, z, x, y))
+	if os.IsNotExist(err) {
+		return nil, &TileError{TileID{z, x, y}, ErrTileNotFound}
+	}
+	return im, err
+}
+
+// MemorySource holds tile images in memory. It is mostly useful for tests.
+type MemorySource struct {
+	Encoding ElevationEncoding
+	mu       sync.RWMutex
+	tiles    map[TileID]image.Image
+}
+
+func NewMemorySource() *MemorySource {
+	return &MemorySource{tiles: make(map[TileID]image.Image)}
+}
+
+func (source *MemorySource) TileEncoding() ElevationEncoding {
+	return encodingOrDefault(source.Encoding)
+}
+
+func (source *MemorySource) SetTile(z, x, y int, im image.Image) {
+	source.mu.Lock()
+	defer source.mu.Unlock()
+	source.tiles[TileID{z, x, y}] = im
+}
+
+func (source *MemorySource) TileImage(z, x, y int) (image.Image, error) {
+	source.mu.RLock()
+	defer source.mu.RUnlock()
+	im, ok := source.tiles[TileID{z, x, y}]
+	if !ok {
+		return nil, &TileError{TileID{z, x, y}, ErrTileNotFound}
+	}
+	return im, nil
+}
+
+// SourceChain tries each source in order and returns the first tile found.
+// Tiles are returned in the encoding of the first source; tiles from
+// sources with a different encoding are converted.
+type SourceChain []TileSource
+
+func (chain SourceChain) TileImage(z, x, y int) (image.Image, error) {
+	err := ErrTileNotFound
+	for _, source := range chain {
+		var im image.Image
+		im, err = source.TileImage(z, x, y)
+		if err == nil {
+			return chain.convert(im, sourceEncoding(source)), nil
+		}
+		if !isNotFound(err) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+func (chain SourceChain) TileEncoding() ElevationEncoding {
+	if len(chain) == 0 {
+		return TerrariumEncoding{}
+	}
+	return sourceEncoding(chain[0])
+}
+
+func (chain SourceChain) convert(im image.Image, encoding ElevationEncoding) image.Image {
+	target := chain.TileEncoding()
+	if sameEncoding(encoding, target) {
+		return im
+	}
+	size := im.Bounds().Size()
+	elevation := encoding.DecodeElevation(im)
+	return target.EncodeElevation(elevation, size.X, size.Y)
+}
+
+// sameEncoding reports whether a and b are known to be equal. Encodings that
+// can't be compared, such as ones holding a lookup table, are never equal.
+func sameEncoding(a, b ElevationEncoding) bool {
+	if reflect.TypeOf(a) != reflect.TypeOf(b) || !reflect.ValueOf(a).Comparable() {
+		return false
+	}
+	return a == b
+}
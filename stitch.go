@@ -5,37 +5,55 @@ import (
 	"image/draw"
 )
 
-func stitchTile(cache *Cache, z, x, y int) (*image.RGBA, error) {
+func stitchTile(source TileSource, z, x, y int) (image.Image, error) {
 	n := 1 << uint(z)
 	x0 := x
 	y0 := y
 	x1 := (x0 + 1) % n
 	y1 := (y0 + 1) % n
 
-	im00, err := cache.getTileImage(z, x0, y0)
+	im00, err := source.TileImage(z, x0, y0)
 	if err != nil {
 		return nil, err
 	}
 
-	im01, err := cache.getTileImage(z, x0, y1)
+	im01, err := source.TileImage(z, x0, y1)
 	if err != nil {
 		return nil, err
 	}
 
-	im10, err := cache.getTileImage(z, x1, y0)
+	im10, err := source.TileImage(z, x1, y0)
 	if err != nil {
 		return nil, err
 	}
 
-	im11, err := cache.getTileImage(z, x1, y1)
+	im11, err := source.TileImage(z, x1, y1)
 	if err != nil {
 		return nil, err
 	}
 
-	im := image.NewRGBA(image.Rect(0, 0, TileSize+1, TileSize+1))
-	draw.Draw(im, image.Rect(0, 0, TileSize, TileSize), im00, image.ZP, draw.Over)
-	draw.Draw(im, image.Rect(0, TileSize, TileSize, TileSize+1), im01, image.ZP, draw.Over)
-	draw.Draw(im, image.Rect(TileSize, 0, TileSize+1, TileSize), im10, image.ZP, draw.Over)
-	draw.Draw(im, image.Rect(TileSize, TileSize, TileSize+1, TileSize+1), im11, image.ZP, draw.Over)
+	// draw with Src into an image of the same kind as the source tiles so
+	// that 16-bit and alpha-encoded elevations survive stitching intact
+	w := im00.Bounds().Size().X
+	h := im00.Bounds().Size().Y
+	im := newImageLike(im00, image.Rect(0, 0, w+1, h+1))
+	draw.Draw(im, image.Rect(0, 0, w, h), im00, im00.Bounds().Min, draw.Src)
+	draw.Draw(im, image.Rect(0, h, w, h+1), im01, im01.Bounds().Min, draw.Src)
+	draw.Draw(im, image.Rect(w, 0, w+1, h), im10, im10.Bounds().Min, draw.Src)
+	draw.Draw(im, image.Rect(w, h, w+1, h+1), im11, im11.Bounds().Min, draw.Src)
 	return im, nil
 }
+
+func newImageLike(im image.Image, r image.Rectangle) draw.Image {
+	switch im.(type) {
+	case *image.Gray16:
+		return image.NewGray16(r)
+	case *image.Gray:
+		return image.NewGray(r)
+	case *image.NRGBA:
+		return image.NewNRGBA(r)
+	case *image.NRGBA64:
+		return image.NewNRGBA64(r)
+	}
+	return image.NewRGBA(r)
+}
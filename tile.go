@@ -9,6 +9,8 @@ import (
 	"github.com/fogleman/maps"
 )
 
+// TileSize is the default tile size in pixels. Tiles of other sizes are
+// supported; their size is taken from the tile images.
 const TileSize = 256
 
 func TileXY(z int, p Point) IntPoint {
@@ -27,12 +29,31 @@ func TileXYFloat(z int, p Point) Point {
 }
 
 func TileLatLng(z, x, y int) Point {
+	return TileLatLngFloat(z, Point{float64(x), float64(y)})
+}
+
+func TileLatLngFloat(z int, p Point) Point {
 	n := math.Pow(2, float64(z))
-	lng := float64(x)/n*360 - 180
-	lat := math.Atan(math.Sinh(math.Pi*(1-2*float64(y)/n))) * 180 / math.Pi
+	lng := p.X/n*360 - 180
+	lat := math.Atan(math.Sinh(math.Pi*(1-2*p.Y/n))) * 180 / math.Pi
 	return Point{lng, lat}
 }
 
+// PixelToLatLng converts global pixel coordinates at zoom z, for tiles of
+// the given size, to a lat/lng using the exact inverse Mercator projection.
+func PixelToLatLng(z, size int, p Point) Point {
+	s := float64(size)
+	return TileLatLngFloat(z, Point{p.X / s, p.Y / s})
+}
+
+// LatLngToPixel converts a lat/lng to global pixel coordinates at zoom z for
+// tiles of the given size.
+func LatLngToPixel(z, size int, p Point) Point {
+	t := TileXYFloat(z, p)
+	s := float64(size)
+	return Point{t.X * s, t.Y * s}
+}
+
 func imageToElevation(im *image.RGBA) []float64 {
 	w := im.Bounds().Size().X
 	h := im.Bounds().Size().Y
@@ -44,7 +65,12 @@ func imageToElevation(im *image.RGBA) []float64 {
 			r := float64(im.Pix[i+0])
 			g := float64(im.Pix[i+1])
 			b := float64(im.Pix[i+2])
+			a := im.Pix[i+3]
 			meters := (r*256 + g + b/256) - 32768
+			if a == 0 || r+g+b == 0 {
+				// transparent or pure black pixels are voids
+				meters = NoData
+			}
 			buf[index] = meters
 			index += 1
 			i += 4
@@ -53,40 +79,89 @@ func imageToElevation(im *image.RGBA) []float64 {
 	return buf
 }
 
+// EncodeTerrarium encodes an elevation grid as a Terrarium RGB image. It is
+// the inverse of the decoding done for Terrarium tiles. NoData values are
+// encoded as transparent pixels.
+func EncodeTerrarium(elevation []float64, w, h int) *image.RGBA {
+	im := image.NewRGBA(image.Rect(0, 0, w, h))
+	index := 0
+	for y := 0; y < h; y++ {
+		i := im.PixOffset(0, y)
+		for x := 0; x < w; x++ {
+			if IsNoData(elevation[index]) {
+				index += 1
+				i += 4
+				continue
+			}
+			v := clamp(elevation[index]+32768, 1.0/256, 65536-1.0/256)
+			r := math.Floor(v / 256)
+			g := math.Floor(v - r*256)
+			b := math.Floor((v - r*256 - g) * 256)
+			im.Pix[i+0] = uint8(r)
+			im.Pix[i+1] = uint8(g)
+			im.Pix[i+2] = uint8(b)
+			im.Pix[i+3] = 255
+			index += 1
+			i += 4
+		}
+	}
+	return im
+}
+
+type TileID struct {
+	Z, X, Y int
+}
+
+// Tile is a decoded elevation tile. W and H are the pixel dimensions of the
+// elevation grid and Size is the pixel size of one tile in its source, which
+// is one less than W and H for stitched tiles. Image is the tile's own copy
+// of the source image, so it can be modified freely.
+//
+// Pixels without data have an Elevation of NoData and are transparent in
+// Valid, which is nil if every pixel is valid. MinElevation and
+// MaxElevation only consider valid pixels and are NoData if there are none.
+//
+// Method selects how contour lines are traced and defaults to Triangles.
 type Tile struct {
 	Z, X, Y      int
 	W, H         int
+	Size         int
 	Image        *image.RGBA
 	Mask         *image.Alpha
+	Valid        *image.Alpha
 	Elevation    []float64
 	MinElevation float64
 	MaxElevation float64
+	Method       ContourMethod
 }
 
-func newTile(z, x, y int, im image.Image) *Tile {
-	rgba, _ := ensureRGBA(im)
+func newTile(z, x, y, size int, im image.Image, decoder ElevationDecoder) *Tile {
+	// the source may hand the same image to every caller, as the memory
+	// cache does, so the tile takes its own copy for callers to modify
+	rgba, shared := ensureRGBA(im)
+	if shared {
+		rgba = &image.RGBA{Pix: append([]uint8(nil), rgba.Pix...), Stride: rgba.Stride, Rect: rgba.Rect}
+	}
 	w := rgba.Bounds().Size().X
 	h := rgba.Bounds().Size().Y
-	elevation := imageToElevation(rgba)
-	lo := elevation[0]
-	hi := elevation[0]
-	for _, e := range elevation {
-		if e < lo {
-			lo = e
-		}
-		if e > hi {
-			hi = e
-		}
-	}
-	return &Tile{z, x, y, w, h, rgba, nil, elevation, lo, hi}
+	elevation := decoder.DecodeElevation(im)
+	valid := validMask(elevation, w, h)
+	lo, hi := elevationRange(elevation)
+	return &Tile{z, x, y, w, h, size, rgba, nil, valid, elevation, lo, hi, Triangles}
 }
 
 func (tile *Tile) AsGray16(lo, hi float64) *image.Gray16 {
-	im := image.NewGray16(tile.Image.Bounds())
-	for y := 0; y < tile.H; y++ {
-		for x := 0; x < tile.W; x++ {
-			i := y*tile.W + x
-			e := tile.Elevation[i]
+	return elevationToGray16(tile.Elevation, tile.W, tile.H, lo, hi)
+}
+
+func elevationToGray16(elevation []float64, w, h int, lo, hi float64) *image.Gray16 {
+	im := image.NewGray16(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			e := elevation[y*w+x]
+			if IsNoData(e) {
+				continue
+			}
 			t := (e - lo) / (hi - lo)
 			if t < 0 {
 				t = 0
@@ -101,60 +176,108 @@ func (tile *Tile) AsGray16(lo, hi float64) *image.Gray16 {
 	return im
 }
 
+// PixelToLatLng converts pixel coordinates within the tile to a lat/lng.
+func (tile *Tile) PixelToLatLng(p Point) Point {
+	s := float64(tile.Size)
+	return PixelToLatLng(tile.Z, tile.Size, Point{p.X + float64(tile.X)*s, p.Y + float64(tile.Y)*s})
+}
+
+// LatLngToPixel converts a lat/lng to pixel coordinates within the tile.
+func (tile *Tile) LatLngToPixel(p Point) Point {
+	q := LatLngToPixel(tile.Z, tile.Size, p)
+	s := float64(tile.Size)
+	return Point{q.X - float64(tile.X)*s, q.Y - float64(tile.Y)*s}
+}
+
 func (tile *Tile) MaskShapes(shapes []maps.Shape) {
 	tile.Mask = tile.renderMask(shapes)
 }
 
 func (tile *Tile) ContourLines(z float64) []Path {
-	return tile.contourLines(z, nil)
+	return joinPairs(tile.contourPairs([]float64{z}, nil)[0])
 }
 
 func (tile *Tile) MaskedContourLines(z float64) []Path {
-	return tile.contourLines(z, tile.Mask)
+	return joinPairs(tile.contourPairs([]float64{z}, tile.Mask)[0])
 }
 
-func (tile *Tile) contourLines(z float64, mask *image.Alpha) []Path {
-	if z < tile.MinElevation || z > tile.MaxElevation {
-		return nil
-	}
-	nw := TileLatLng(tile.Z, tile.X, tile.Y)
-	se := TileLatLng(tile.Z, tile.X+1, tile.Y+1)
-	pairs := slice(tile.Elevation, tile.W, tile.H, z+1e-7)
-	if mask != nil {
-		maskedPairs := pairs[:0]
-		for _, p := range pairs {
-			if mask.AlphaAt(int(p.A.X), int(p.A.Y)).A != 255 {
-				continue
-			}
-			if mask.AlphaAt(int(p.B.X), int(p.B.Y)).A != 255 {
-				continue
+// ContourLevels returns the contour lines at each of the levels, in the
+// order given, tracing them all in a single pass over the tile.
+func (tile *Tile) ContourLevels(levels []float64) [][]Path {
+	return pairsToLevels(tile.contourPairs(levels, nil))
+}
+
+func (tile *Tile) MaskedContourLevels(levels []float64) [][]Path {
+	return pairsToLevels(tile.contourPairs(levels, tile.Mask))
+}
+
+// ContourFragments returns the contour lines at the levels as fragments
+// that can be joined with those of neighbouring stitched tiles using
+// JoinFragments.
+func (tile *Tile) ContourFragments(levels ...float64) []Fragment {
+	return levelsToFragments(tile.contourPairs(levels, nil), levels)
+}
+
+func (tile *Tile) MaskedContourFragments(levels ...float64) []Fragment {
+	return levelsToFragments(tile.contourPairs(levels, tile.Mask), levels)
+}
+
+// Contours returns the contour lines at the levels in lat/lng, tagged
+// with their level.
+func (tile *Tile) Contours(levels Levels) []Contour {
+	return JoinContours(tile.ContourFragments(levels.Values()...), levels)
+}
+
+func (tile *Tile) MaskedContours(levels Levels) []Contour {
+	return JoinContours(tile.MaskedContourFragments(levels.Values()...), levels)
+}
+
+func (tile *Tile) contourPairs(levels []float64, mask *image.Alpha) [][]pair {
+	result := sliceLevels(tile.Method, tile.Elevation, tile.W, tile.H, levels)
+	dx := tile.X * tile.Size
+	dy := tile.Y * tile.Size
+	wrap := tile.Size << uint(tile.Z)
+	for i, pairs := range result {
+		if mask != nil {
+			maskedPairs := pairs[:0]
+			for _, p := range pairs {
+				if mask.AlphaAt(int(p.A.X), int(p.A.Y)).A != 255 {
+					continue
+				}
+				if mask.AlphaAt(int(p.B.X), int(p.B.Y)).A != 255 {
+					continue
+				}
+				maskedPairs = append(maskedPairs, p)
 			}
-			maskedPairs = append(maskedPairs, p)
+			pairs = maskedPairs
 		}
-		pairs = maskedPairs
-	}
-	for i, p := range pairs {
-		x0 := nw.X + (se.X-nw.X)*(p.A.X/TileSize)
-		y0 := nw.Y + (se.Y-nw.Y)*(p.A.Y/TileSize)
-		x1 := nw.X + (se.X-nw.X)*(p.B.X/TileSize)
-		y1 := nw.Y + (se.Y-nw.Y)*(p.B.Y/TileSize)
-		pairs[i] = pair{Point{x0, y0}, Point{x1, y1}}
+		for j, p := range pairs {
+			pairs[j] = pair{
+				tile.PixelToLatLng(p.A), tile.PixelToLatLng(p.B),
+				p.KA.offset(dx, dy, wrap), p.KB.offset(dx, dy, wrap)}
+		}
+		result[i] = pairs
 	}
-	return joinPairs(pairs)
+	return result
 }
 
+func (tile *Tile) IsValid(x, y int) bool {
+	return !IsNoData(tile.Elevation[y*tile.W+x])
+}
+
+// MaskedElevation returns a copy of the elevations with pixels outside the
+// mask set to NoData.
 func (tile *Tile) MaskedElevation() []float64 {
 	elevation := make([]float64, len(tile.Elevation))
 	copy(elevation, tile.Elevation)
 	mask := tile.Mask
 	if mask != nil {
-		sentinel := tile.MinElevation - 1
 		i := 0
-		for y := 0; y < TileSize; y++ {
+		for y := 0; y < tile.H; y++ {
 			j := mask.PixOffset(0, y)
-			for x := 0; x < TileSize; x++ {
+			for x := 0; x < tile.W; x++ {
 				if mask.Pix[j] != 255 {
-					elevation[i] = sentinel
+					elevation[i] = NoData
 				}
 				i++
 				j++
@@ -165,16 +288,17 @@ func (tile *Tile) MaskedElevation() []float64 {
 }
 
 func (tile *Tile) renderMask(shapes []maps.Shape) *image.Alpha {
-	nw := TileLatLng(tile.Z, tile.X, tile.Y)
-	se := TileLatLng(tile.Z, tile.X+1, tile.Y+1)
-	dc := gg.NewContext(TileSize, TileSize)
+	return renderMask(tile.W, tile.H, shapes, tile.LatLngToPixel)
+}
+
+func renderMask(w, h int, shapes []maps.Shape, toPixel func(Point) Point) *image.Alpha {
+	dc := gg.NewContext(w, h)
 	for _, shape := range shapes {
 		for _, line := range shape.Lines {
 			dc.NewSubPath()
 			for _, p := range line.Points {
-				x := (p.X - nw.X) / (se.X - nw.X) * TileSize
-				y := (p.Y - nw.Y) / (se.Y - nw.Y) * TileSize
-				dc.LineTo(x, y)
+				q := toPixel(Point(p))
+				dc.LineTo(q.X, q.Y)
 			}
 		}
 	}
@@ -0,0 +1,94 @@
+package terrarium
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPixelLatLngRoundTrip(t *testing.T) {
+	for _, size := range []int{256, 512} {
+		for _, z := range []int{0, 5, 9, 12, 15} {
+			for _, p := range []Point{
+				LatLng(0, 0), LatLng(43.5, -110.78), LatLng(-33.9, 151.2),
+				LatLng(84.9, 179.9), LatLng(-84.9, -179.9),
+			} {
+				q := PixelToLatLng(z, size, LatLngToPixel(z, size, p))
+				if math.Abs(q.X-p.X) > 1e-9 || math.Abs(q.Y-p.Y) > 1e-9 {
+					t.Errorf("size %d zoom %d: %v round trips to %v", size, z, p, q)
+				}
+			}
+		}
+	}
+}
+
+func TestPixelToLatLngTileCorners(t *testing.T) {
+	for _, size := range []int{256, 512} {
+		for _, z := range []int{1, 5, 9} {
+			n := 1 << uint(z)
+			for _, c := range []IntPoint{{0, 0}, {n / 2, n / 3}, {n - 1, n - 1}, {n, n}} {
+				want := TileLatLng(z, c.X, c.Y)
+				got := PixelToLatLng(z, size, Point{float64(c.X * size), float64(c.Y * size)})
+				if math.Abs(got.X-want.X) > 1e-9 || math.Abs(got.Y-want.Y) > 1e-9 {
+					t.Errorf("size %d tile %d/%d/%d: corner %v, want %v", size, z, c.X, c.Y, got, want)
+				}
+			}
+		}
+	}
+}
+
+// linearLatLng is the approximation previously used by contourLines and
+// renderMask, interpolating linearly between the tile corners.
+func linearLatLng(z, x, y, size int, p Point) Point {
+	nw := TileLatLng(z, x, y)
+	se := TileLatLng(z, x+1, y+1)
+	s := float64(size)
+	return Point{nw.X + (se.X-nw.X)*(p.X/s), nw.Y + (se.Y-nw.Y)*(p.Y/s)}
+}
+
+func TestLinearLatLngError(t *testing.T) {
+	// worst case latitude error of the linear approximation across the
+	// 256px tile containing 43.5N, in degrees
+	cases := []struct {
+		Z        int
+		Min, Max float64
+	}{
+		{5, 0.1, 0.2},
+		{9, 4e-4, 7e-4},
+		{12, 5e-6, 1.5e-5},
+	}
+	for _, c := range cases {
+		tile := TileXY(c.Z, LatLng(43.5, -110.78))
+		tx, ty := float64(tile.X*TileSize), float64(tile.Y*TileSize)
+		var worst float64
+		for py := 0; py <= TileSize; py++ {
+			p := Point{128, float64(py)}
+			exact := PixelToLatLng(c.Z, TileSize, Point{tx + p.X, ty + p.Y})
+			approx := linearLatLng(c.Z, tile.X, tile.Y, TileSize, p)
+			worst = math.Max(worst, math.Abs(exact.Y-approx.Y))
+		}
+		if worst < c.Min || worst > c.Max {
+			t.Errorf("zoom %d: worst error %g degrees, want between %g and %g",
+				c.Z, worst, c.Min, c.Max)
+		}
+		t.Logf("zoom %d: worst latitude error %.3g degrees", c.Z, worst)
+	}
+}
+
+func TestTileImageIsNotShared(t *testing.T) {
+	source := NewMemorySource()
+	source.SetTile(0, 0, 0, EncodeTerrarium(make([]float64, 4*4), 4, 4))
+	tile, err := GetTile(source, 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range tile.Image.Pix {
+		tile.Image.Pix[i] = 0
+	}
+	tile, err = GetTile(source, 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tile.Image.Pix[3] != 255 {
+		t.Error("modifying a tile's image changed the source's copy")
+	}
+}
@@ -0,0 +1,166 @@
+package terrarium
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const earthRadius = 6371008.8
+
+// HaversineDistance returns the great circle distance in meters between two
+// LatLng points.
+func HaversineDistance(a, b Point) float64 {
+	lat1 := a.Y * math.Pi / 180
+	lat2 := b.Y * math.Pi / 180
+	dlat := lat2 - lat1
+	dlng := (b.X - a.X) * math.Pi / 180
+	h := math.Sin(dlat/2)*math.Sin(dlat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dlng/2)*math.Sin(dlng/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// ReadGPX reads the track points, or failing that the route points, of a
+// GPX file as a LatLng path. Multiple tracks and segments are concatenated.
+func ReadGPX(r io.Reader) (Path, error) {
+	type gpxPoint struct {
+		Lat float64 `xml:"lat,attr"`
+		Lon float64 `xml:"lon,attr"`
+	}
+	var gpx struct {
+		Tracks []struct {
+			Segments []struct {
+				Points []gpxPoint `xml:"trkpt"`
+			} `xml:"trkseg"`
+		} `xml:"trk"`
+		Routes []struct {
+			Points []gpxPoint `xml:"rtept"`
+		} `xml:"rte"`
+	}
+	if err := xml.NewDecoder(r).Decode(&gpx); err != nil {
+		return nil, err
+	}
+	var path Path
+	for _, track := range gpx.Tracks {
+		for _, segment := range track.Segments {
+			for _, p := range segment.Points {
+				path = append(path, LatLng(p.Lat, p.Lon))
+			}
+		}
+	}
+	if len(path) == 0 {
+		for _, route := range gpx.Routes {
+			for _, p := range route.Points {
+				path = append(path, LatLng(p.Lat, p.Lon))
+			}
+		}
+	}
+	if len(path) == 0 {
+		return nil, errors.New("gpx: no track or route points")
+	}
+	return path, nil
+}
+
+// ReadGeoJSONLineString reads the first LineString (or the lines of the
+// first MultiLineString, concatenated) from a GeoJSON geometry, Feature or
+// FeatureCollection.
+func ReadGeoJSONLineString(r io.Reader) (Path, error) {
+	var object geoJSONObject
+	if err := json.NewDecoder(r).Decode(&object); err != nil {
+		return nil, err
+	}
+	path := object.lineString()
+	if len(path) == 0 {
+		return nil, errors.New("geojson: no LineString found")
+	}
+	return path, nil
+}
+
+type geoJSONObject struct {
+	Type        string           `json:"type"`
+	Coordinates json.RawMessage  `json:"coordinates"`
+	Geometry    *geoJSONObject   `json:"geometry"`
+	Features    []*geoJSONObject `json:"features"`
+	Geometries  []*geoJSONObject `json:"geometries"`
+}
+
+func (object *geoJSONObject) lineString() Path {
+	switch object.Type {
+	case "LineString":
+		var coords [][]float64
+		if json.Unmarshal(object.Coordinates, &coords) != nil {
+			return nil
+		}
+		return coordsToPath(coords)
+	case "MultiLineString":
+		var lines [][][]float64
+		if json.Unmarshal(object.Coordinates, &lines) != nil {
+			return nil
+		}
+		var path Path
+		for _, coords := range lines {
+			path = append(path, coordsToPath(coords)...)
+		}
+		return path
+	case "Feature":
+		if object.Geometry != nil {
+			return object.Geometry.lineString()
+		}
+	case "FeatureCollection", "GeometryCollection":
+		children := object.Features
+		if object.Type == "GeometryCollection" {
+			children = object.Geometries
+		}
+		for _, child := range children {
+			if path := child.lineString(); len(path) > 0 {
+				return path
+			}
+		}
+	}
+	return nil
+}
+
+func coordsToPath(coords [][]float64) Path {
+	var path Path
+	for _, c := range coords {
+		if len(c) >= 2 {
+			path = append(path, LatLng(c[1], c[0]))
+		}
+	}
+	return path
+}
+
+// Resample returns points along a LatLng path spaced every spacing meters,
+// always including the first and last points, along with the distance of
+// each from the start.
+func (path Path) Resample(spacing float64) (Path, []float64, error) {
+	if len(path) == 0 {
+		return nil, nil, nil
+	}
+	if spacing <= 0 {
+		return nil, nil, fmt.Errorf("invalid spacing %g", spacing)
+	}
+	result := Path{path[0]}
+	distances := []float64{0}
+	total := 0.0
+	next := spacing
+	for i := 1; i < len(path); i++ {
+		a, b := path[i-1], path[i]
+		d := HaversineDistance(a, b)
+		for next <= total+d {
+			t := (next - total) / d
+			result = append(result, Point{a.X + (b.X-a.X)*t, a.Y + (b.Y-a.Y)*t})
+			distances = append(distances, next)
+			next += spacing
+		}
+		total += d
+	}
+	if total > distances[len(distances)-1] {
+		result = append(result, path[len(path)-1])
+		distances = append(distances, total)
+	}
+	return result, distances, nil
+}
@@ -1,8 +1,12 @@
 package terrarium
 
 import (
+	"bytes"
 	"image"
 	"image/draw"
+	"io"
+	"os"
+	"path/filepath"
 )
 
 func ensureRGBA(im image.Image) (*image.RGBA, bool) {
@@ -15,3 +19,64 @@ func ensureRGBA(im image.Image) (*image.RGBA, bool) {
 		return dst, false
 	}
 }
+
+func ensureNRGBA(im image.Image) *image.NRGBA {
+	switch im := im.(type) {
+	case *image.NRGBA:
+		return im
+	default:
+		dst := image.NewNRGBA(im.Bounds())
+		draw.Draw(dst, im.Bounds(), im, im.Bounds().Min, draw.Src)
+		return dst
+	}
+}
+
+func clamp(v, lo, hi float64) float64 {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+// writeAtomic writes to a temporary file and renames it into place so that
+// a failed or interrupted write never leaves a truncated file at path.
+func writeAtomic(path string, r io.Reader) (int64, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return 0, err
+	}
+	file, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(file, r)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return 0, err
+	}
+	return n, nil
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	_, err := writeAtomic(path, bytes.NewReader(data))
+	return err
+}
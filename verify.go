@@ -0,0 +1,199 @@
+package terrarium
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type VerifyAction int
+
+const (
+	// VerifyReport only reports bad tiles.
+	VerifyReport VerifyAction = iota
+	// VerifyDelete deletes bad tiles.
+	VerifyDelete
+	// VerifyRedownload deletes bad tiles and downloads them again.
+	VerifyRedownload
+)
+
+type VerifyResult struct {
+	Checked   int
+	Bad       TileErrors
+	Deleted   int
+	Repaired  int
+	TempFiles int
+}
+
+// staleTempAge is how long a temporary file must go unwritten before Verify
+// treats it as left over rather than belonging to a download in progress.
+const staleTempAge = time.Hour
+
+// Verify walks the cache directory and checks that every tile decodes to an
+// image of the cache's tile size. Temporary files that have not been written
+// to for staleTempAge are left over from interrupted downloads and removed.
+// With VerifyRedownload, failures to download a bad tile again are returned
+// as a TileErrors and, unlike other download failures, not also by Wait.
+func (cache *Cache) Verify(action VerifyAction) (*VerifyResult, error) {
+	result := &VerifyResult{}
+	now := time.Now()
+	err := filepath.Walk(cache.Directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".tmp") {
+			return nil
+		}
+		if now.Sub(info.ModTime()) <= staleTempAge {
+			return nil
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		result.TempFiles++
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return result, err
+	}
+	err = walkTiles(cache.Directory, func(id TileID, path string, info os.FileInfo) error {
+		result.Checked++
+		if err := checkTileFile(path, true, cache.tileSize); err != nil {
+			result.Bad = append(result.Bad, &TileError{id, err})
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return result, err
+	}
+	if action == VerifyReport {
+		return result, nil
+	}
+	for _, e := range result.Bad {
+		if err := cache.removeTile(e.TileID); err != nil {
+			return result, err
+		}
+		result.Deleted++
+	}
+	if action != VerifyRedownload || len(result.Bad) == 0 {
+		return result, nil
+	}
+	calls := make([]*flightCall, len(result.Bad))
+	for i, e := range result.Bad {
+		calls[i] = cache.ensureTile(context.Background(), e.Z, e.X, e.Y, false)
+	}
+	var errs TileErrors
+	for i, call := range calls {
+		<-call.done
+		if call.err != nil {
+			errs = append(errs, &TileError{result.Bad[i].TileID, call.err})
+		}
+	}
+	result.Repaired = len(result.Bad) - len(errs)
+	if len(errs) == 0 {
+		return result, nil
+	}
+	return result, errs
+}
+
+type PruneOptions struct {
+	// MaxAge removes tiles last downloaded longer ago than this.
+	MaxAge time.Duration
+	// Zooms removes all tiles at these zoom levels.
+	Zooms []int
+	// Bounds removes tiles, at any zoom, that overlap these bounds.
+	Bounds *Bounds
+	// MaxBytes removes the oldest remaining tiles until the cache is no
+	// larger than this.
+	MaxBytes int64
+	// DryRun reports what would be removed without removing anything.
+	DryRun bool
+}
+
+type PruneResult struct {
+	Removed        int
+	RemovedBytes   int64
+	Remaining      int
+	RemainingBytes int64
+}
+
+// Prune removes tiles from the cache directory. A tile is removed if it
+// matches any of the given criteria; zero-valued criteria are ignored.
+func (cache *Cache) Prune(options PruneOptions) (*PruneResult, error) {
+	type entry struct {
+		id      TileID
+		size    int64
+		modTime time.Time
+	}
+	zooms := make(map[int]bool)
+	for _, z := range options.Zooms {
+		zooms[z] = true
+	}
+	ranges := make(map[int]TileRange)
+	now := time.Now()
+	result := &PruneResult{}
+	var remove, keep []entry
+	err := walkTiles(cache.Directory, func(id TileID, path string, info os.FileInfo) error {
+		e := entry{id, info.Size(), info.ModTime()}
+		matched := zooms[id.Z]
+		if options.MaxAge > 0 && now.Sub(e.modTime) > options.MaxAge {
+			matched = true
+		}
+		if options.Bounds != nil {
+			r, ok := ranges[id.Z]
+			if !ok {
+				r = TileRangeForBounds(id.Z, *options.Bounds)
+				ranges[id.Z] = r
+			}
+			if r.Contains(id) {
+				matched = true
+			}
+		}
+		if matched {
+			remove = append(remove, e)
+		} else {
+			keep = append(keep, e)
+			result.RemainingBytes += e.size
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if options.MaxBytes > 0 && result.RemainingBytes > options.MaxBytes {
+		sort.Slice(keep, func(i, j int) bool {
+			return keep[i].modTime.Before(keep[j].modTime)
+		})
+		for len(keep) > 0 && result.RemainingBytes > options.MaxBytes {
+			remove = append(remove, keep[0])
+			result.RemainingBytes -= keep[0].size
+			keep = keep[1:]
+		}
+	}
+	result.Remaining = len(keep)
+	for _, e := range remove {
+		if !options.DryRun {
+			if err := cache.removeTile(e.id); err != nil {
+				return result, err
+			}
+		}
+		result.Removed++
+		result.RemovedBytes += e.size
+	}
+	return result, nil
+}
+
+func (cache *Cache) removeTile(id TileID) error {
+	path := cache.tilePath(id.Z, id.X, id.Y)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	os.Remove(tileMetaPath(path))
+	if cache.memory != nil {
+		cache.memory.Remove(id)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package terrarium
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeBadTile(t *testing.T, cache *Cache, z, x, y int) string {
+	path := cache.tilePath(z, x, y)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("not a png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestVerifyRedownload(t *testing.T) {
+	server, _ := newTileServer(t, 0)
+	cache := NewCache(server.URL+"/{z}/{x}/{y}.png", t.TempDir(), 4, WithTileSize(16))
+
+	path := writeBadTile(t, cache, 3, 5, 5)
+	active := path + ".1.tmp"
+	stale := path + ".2.tmp"
+	for _, name := range []string{active, stale} {
+		if err := os.WriteFile(name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-2 * staleTempAge)
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	// an earlier failure belongs to whoever calls Wait, not to Verify
+	other := &TileError{TileID{1, 0, 0}, errors.New("other")}
+	cache.report(other.TileID, TileFailed, 0, other.Err, true)
+
+	result, err := cache.Verify(VerifyRedownload)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if len(result.Bad) != 1 || result.Repaired != 1 {
+		t.Errorf("got %d bad and %d repaired, want 1 and 1", len(result.Bad), result.Repaired)
+	}
+	if result.TempFiles != 1 {
+		t.Errorf("removed %d temp files, want 1", result.TempFiles)
+	}
+	if _, err := os.Stat(active); err != nil {
+		t.Errorf("recent temp file was removed: %v", err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale temp file was kept")
+	}
+	if err := checkTileFile(path, true, 16); err != nil {
+		t.Errorf("tile was not repaired: %v", err)
+	}
+	errs, ok := cache.Wait().(TileErrors)
+	if !ok || len(errs) != 1 || errs[0].TileID != other.TileID {
+		t.Errorf("Wait after Verify returned %v, want the earlier failure", errs)
+	}
+}
+
+func TestVerifyRedownloadFailure(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	cache := NewCache(server.URL+"/{z}/{x}/{y}.png", t.TempDir(), 4, WithTileSize(16))
+	writeBadTile(t, cache, 3, 5, 5)
+
+	result, err := cache.Verify(VerifyRedownload)
+	errs, ok := err.(TileErrors)
+	if !ok || len(errs) != 1 || errs[0].TileID != (TileID{3, 5, 5}) {
+		t.Fatalf("Verify returned %v, want the failed redownload", err)
+	}
+	if result.Repaired != 0 {
+		t.Errorf("repaired %d tiles, want 0", result.Repaired)
+	}
+	if err := cache.Wait(); err != nil {
+		t.Errorf("Wait after Verify returned Verify's failure: %v", err)
+	}
+}